@@ -10,8 +10,7 @@ import (
 func calcAndDrawDensity(sph *sim.Simulation, kernel sim.Kernel, canvas *gx.Canvas, side, positionIndex int, colorRamp func(uint8) gx.Color) {
 	// Calculate Nearest Neighbor Density Rho
 	for i, _ := range sph.Root.Particles {
-		p := &sph.Root.Particles[i]
-		p.Rho = sim.Density2D(p, sph, kernel)
+		sph.Root.Particles[i].Rho = sim.Density2D(i, sph, kernel)
 	}
 
 	// draw it for all particles
@@ -30,7 +29,7 @@ func calcAndDrawDensity(sph *sim.Simulation, kernel sim.Kernel, canvas *gx.Canva
 		color := colorRamp(color_index)
 
 		if color_index > 255 {
-			nnRadius := float32(particle.NNDists[0]) * float32(canvas.W)
+			nnRadius := float32(particle.H) * float32(canvas.W)
 			canvas.DrawCircle(x, y, nnRadius, 2, gx.WHITE)
 		}
 
@@ -51,12 +50,14 @@ func main() {
 		UpperLeft:  sim.Vec2{0, 0},
 		LowerRight: sim.Vec2{1, 1},
 		NParticles: 1000,
+		Mass:       sph.Config.ParticleMass,
 	}
 
 	spawner2 := sim.UniformRectSpawner{
 		UpperLeft:  sim.Vec2{0.1, 0},
 		LowerRight: sim.Vec2{0.3, 0.4},
 		NParticles: 200,
+		Mass:       sph.Config.ParticleMass,
 	}
 
 	ps := spawner1.Spawn(0)
@@ -67,9 +68,9 @@ func main() {
 	sph.Root.BoundingSpheres()
 
 	// claculate all nearest neighbours
-	for i, _ := range sph.Root.Particles {
-		sph.Root.Particles[i].FindNearestNeighboursPeriodic(sph.Root, [2]float64{0, 1}, [2]float64{0, 1})
-	}
+	sph.Config.HorPeriodicity = [2]float64{0, 1}
+	sph.Config.VertPeriodicity = [2]float64{0, 1}
+	sph.FindNearestNeighbours()
 
 	side := 420
 	w := 3 * side
@@ -108,12 +109,14 @@ func periodicVisualTest() {
 		UpperLeft:  sim.Vec2{0.1, 0.1},
 		LowerRight: sim.Vec2{0.9, 0.9},
 		NParticles: 10000,
+		Mass:       sph.Config.ParticleMass,
 	}
 
 	spawner2 := sim.UniformRectSpawner{
 		UpperLeft:  sim.Vec2{0.85, 0.4},
 		LowerRight: sim.Vec2{0.9, 0.9},
 		NParticles: 1200,
+		Mass:       sph.Config.ParticleMass,
 	}
 
 	ps := spawner1.Spawn(0)
@@ -126,19 +129,14 @@ func periodicVisualTest() {
 		sph.Root.BoundingSpheres()
 
 		// claculate all nearest neighbours
-		if i == 0 {
-			for i, _ := range sph.Root.Particles {
-				sph.Root.Particles[i].FindNearestNeighbours(sph.Root)
-			}
-		} else {
-			for i, _ := range sph.Root.Particles {
-				sph.Root.Particles[i].FindNearestNeighboursPeriodic(sph.Root, [2]float64{0.1, 0.9}, [2]float64{0.1, 0.9})
-			}
+		if i == 1 {
+			sph.Config.HorPeriodicity = [2]float64{0.1, 0.9}
+			sph.Config.VertPeriodicity = [2]float64{0.1, 0.9}
 		}
+		sph.FindNearestNeighbours()
 
 		for i, _ := range sph.Root.Particles {
-			p := &sph.Root.Particles[i]
-			p.Rho = sim.Density2D(p, &sph, kernel)
+			sph.Root.Particles[i].Rho = sim.Density2D(i, &sph, kernel)
 		}
 
 		// draw it for all particles
@@ -155,7 +153,7 @@ func periodicVisualTest() {
 			//color := gx.RainbowRamp(color_index)
 
 			if color_index > 255 {
-				nnRadius := float32(particle.NNDists[0]) * float32(canvas.W)
+				nnRadius := float32(particle.H) * float32(canvas.W)
 				canvas.DrawCircle(x, y, nnRadius, 2, gx.WHITE)
 			}
 
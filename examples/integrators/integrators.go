@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/bbeni/sphugo/sim"
+	"math"
+)
+
+// Runs the same scene with every integrator and compares the energy drift
+func main() {
+
+	nParticles := flag.Int("n", 2000, "number of particles")
+	nSteps := flag.Int("steps", 200, "number of steps")
+	dtHalf := flag.Float64("dt", 0.002, "half time step")
+	configPath := flag.String("config", "", "config file of the scene, a blob of gas if empty")
+	flag.Parse()
+
+	names := []string{"Leapfrog", "VelocityVerlet", "SemiImplicitEuler", "RungeKutta"}
+
+	fmt.Println("Integrator         Symplectic  Energy drift")
+	for _, name := range names {
+		integrator := sim.Integrators[name]
+
+		conf := sim.MakeConfig()
+		if *configPath != "" {
+			var err error
+			conf, err = sim.MakeConfigFromFile(*configPath)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		} else {
+			spwn := sim.MakeUniformRectSpawner()
+			spwn.NParticles = *nParticles
+			conf.Start = append(conf.Start, spwn)
+			conf.DeltaTHalf = *dtHalf
+		}
+		conf.Integrator = integrator
+		conf.AdaptiveTimeStep = false
+		conf.HierarchicalTimeStep = false
+
+		sph := sim.MakeSimulationFromConf(conf)
+		start := sph.TotalEnergy() + sph.TotalKineticEnergy()
+		for range *nSteps {
+			sph.Step()
+		}
+		end := sph.TotalEnergy() + sph.TotalKineticEnergy()
+
+		fmt.Printf("%-18v %-11v %.4e\n", name, integrator.Symplectic(), math.Abs(end-start)/start)
+	}
+}
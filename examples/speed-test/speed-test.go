@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/bbeni/sphugo/sim"
 	"os"
@@ -10,6 +11,25 @@ import (
 
 func main() {
 
+	nParticles := flag.Int("n", 100000, "number of particles")
+	nSteps := flag.Int("steps", 20, "number of steps")
+	refit := flag.Bool("refit", false, "refit the tree instead of rebuilding it every force evaluation")
+	periodic := flag.Bool("periodic", false, "periodic boundaries around the unit square")
+	workers := flag.Int("workers", 0, "goroutines for the force calculation, 0 uses all cores")
+	order := flag.String("order", "None", "particle ordering before the tree build: None, Morton, Hilbert or All to compare them")
+	flag.Parse()
+
+	orders := []string{*order}
+	if *order == "All" {
+		orders = []string{"None", "Morton", "Hilbert"}
+	}
+	for _, name := range orders {
+		if _, ok := sim.ParticleOrders[name]; !ok {
+			fmt.Printf("unknown ordering `%v`\n", name)
+			return
+		}
+	}
+
 	f, err := os.Create("myprogram.prof")
 	if err != nil {
 		fmt.Println(err)
@@ -19,20 +39,43 @@ func main() {
 	pprof.StartCPUProfile(f)
 	defer pprof.StopCPUProfile()
 
+	fps := make([]float64, len(orders))
+	for i, name := range orders {
+		fmt.Println("Ordering", name)
+		fps[i] = run(*nParticles, *nSteps, *refit, *periodic, *workers, sim.ParticleOrders[name])
+	}
+
+	if len(orders) > 1 {
+		fmt.Println("\nOrdering   FPS      Speedup")
+		for i, name := range orders {
+			fmt.Printf("%-10v %-8.4v %.3v\n", name, fps[i], fps[i]/fps[0])
+		}
+	}
+}
+
+// runs the simulation and returns the average FPS
+func run(nParticles, nSteps int, refit, periodic bool, workers int, order sim.CurveKey) float64 {
 	spwn := sim.MakeUniformRectSpawner()
-	spwn.NParticles = 100000
+	spwn.NParticles = nParticles
 
 	conf := sim.MakeConfig()
 	conf.Start = append(conf.Start, spwn)
 	conf.DeltaTHalf = 0.02
 	conf.Acceleration = sim.Vec2{0, 0.2}
+	conf.TreeRefit = refit
+	conf.ParticleOrder = order
+	conf.Workers = workers
+	if periodic {
+		conf.HorPeriodicity = [2]float64{0, 1}
+		conf.VertPeriodicity = [2]float64{0, 1}
+	}
 
 	sph := sim.MakeSimulationFromConf(conf)
 
 	previous := time.Now()
 	total := 0.0
 
-	for i := range 20 {
+	for i := range nSteps {
 		sph.Step()
 
 		elapsed := time.Since(previous).Seconds()
@@ -42,5 +85,6 @@ func main() {
 		fmt.Println("Step", i, "FPS", 1/elapsed)
 	}
 
-	fmt.Printf("Took %.4v seconds, and got an average FPS of %.4v", total, 20/total)
+	fmt.Printf("Took %.4v seconds, and got an average FPS of %.4v\n", total, float64(nSteps)/total)
+	return float64(nSteps) / total
 }
@@ -9,6 +9,8 @@ For more info and implementation details see tg/tree-partition.go
 package main
 
 import (
+	"fmt"
+
 	"github.com/bbeni/sphugo/sim"
 )
 
@@ -45,4 +47,37 @@ func main() {
 	canvas := sim.MakeTreePlot(&root, IMAGE_W, IMAGE_H)
 	canvas.ToPNG(TREE_PNG_FNAME)
 
+	compareSplitStrategies()
+}
+
+// Builds the tree of a clustered scene (a dense column and a point source
+// in a uniform background) with every split strategy and reports the shape.
+func compareSplitStrategies() {
+
+	column := sim.UniformRectSpawner{
+		UpperLeft:  sim.Vec2{0.05, 0.4},
+		LowerRight: sim.Vec2{0.2, 1},
+		NParticles: N_PARTICLES,
+	}
+	source := sim.UniformRectSpawner{
+		UpperLeft:  sim.Vec2{0.7, 0.2},
+		LowerRight: sim.Vec2{0.701, 0.201},
+		NParticles: N_PARTICLES / 4,
+	}
+	background := sim.UniformRectSpawner{
+		LowerRight: sim.Vec2{1, 1},
+		NParticles: N_PARTICLES / 4,
+	}
+
+	for _, name := range []string{"Alternating", "LongestAxis", "Median", "Variance"} {
+		ps := column.Spawn(0)
+		ps = append(ps, source.Spawn(0)...)
+		ps = append(ps, background.Spawn(0)...)
+
+		root := sim.MakeCellsInBox(ps, sim.Vertical, sim.SplitStrategies[name], sim.Vec2{0, 0}, sim.Vec2{1, 1})
+		fmt.Printf("%-12v %v\n", name, root.Stats())
+
+		canvas := sim.MakeTreePlot(root, IMAGE_W, IMAGE_H)
+		canvas.ToPNG(fmt.Sprintf("tree_%v.png", name))
+	}
 }
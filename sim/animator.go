@@ -1,6 +1,7 @@
 package sim
 
 import (
+	"cmp"
 	"fmt"
 	"image"
 	"image/draw"
@@ -8,6 +9,7 @@ import (
 	"log"
 	"math"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/bbeni/sphugo/gx"
@@ -20,14 +22,18 @@ type Animator struct {
 
 	//frames for rendering
 	Frames []image.Image
+	Times  []float64 // simulated time of each frame
 	//FramesMu sync.Mutex
 
 	// as refernce to simulation variables
 	Simulation *Simulation
 
-	// reference to particles
+	// draw the surface normals, they are only calculated with surface tension
+	ShowNormals bool
+
+	// indices of the particles
 	// also used to order particles acoording to z-value before rendering
-	renderingParticleArray []*Particle
+	renderingParticleArray []int
 }
 
 func MakeAnimator(simulation *Simulation) Animator {
@@ -36,16 +42,10 @@ func MakeAnimator(simulation *Simulation) Animator {
 		panic("int Run(): Simulation not initialized!")
 	}
 
-	ani := Animator{
-		renderingParticleArray: make([]*Particle, len(simulation.Root.Particles)),
-	}
-
-	for i := range simulation.Root.Particles {
-		ani.renderingParticleArray[i] = &simulation.Root.Particles[i]
-	}
-
+	ani := Animator{}
 	ani.Simulation = simulation
 	ani.Frames = make([]image.Image, 0, simulation.Config.NSteps)
+	ani.Times = make([]float64, 0, simulation.Config.NSteps)
 
 	// render first frame
 	ani.Frame()
@@ -57,23 +57,35 @@ func (ani *Animator) CurrentFrame() gx.Canvas {
 	// order according to z-value
 	//
 
-	ani.renderingParticleArray = make([]*Particle, len(ani.Simulation.Root.Particles))
+	particles := ani.Simulation.Root.Particles
+	state := &ani.Simulation.State
+	ani.renderingParticleArray = make([]int, len(particles))
 
-	for i := range ani.Simulation.Root.Particles {
-		ani.renderingParticleArray[i] = &ani.Simulation.Root.Particles[i]
+	for i := range particles {
+		ani.renderingParticleArray[i] = i
 	}
 
-	extractZindex := func(p *Particle) int {
-		//return int(p.Rho*100000)
-		return -p.Z
+	extractZindex := func(i int) int {
+		//return int(particles[i].Rho*100000)
+		return -particles[i].Z
 	}
 
-	QuickSort(ani.renderingParticleArray, extractZindex)
+	slices.SortFunc(ani.renderingParticleArray, func(a, b int) int {
+		return cmp.Compare(extractZindex(a), extractZindex(b))
+	})
 
 	canvas := gx.NewCanvas(1280, 720)
 	canvas.Clear(gx.BLACK)
 
-	for _, particle := range ani.renderingParticleArray {
+	for _, i := range ani.renderingParticleArray {
+		particle := &particles[i]
+
+		// no state before the first step of a tree set up by hand
+		species, wall, normal := 0, false, Vec2{}
+		if i < state.Len() {
+			species, wall, normal = state.Species[i], state.Wall[i], state.Normal[i]
+		}
+
 		x := float32(particle.Pos.X) * float32(canvas.W)
 		y := float32(particle.Pos.Y) * float32(canvas.H)
 
@@ -81,23 +93,33 @@ func (ani *Animator) CurrentFrame() gx.Canvas {
 		//color_index := 255 - uint8((particle.Rho - 1)*64)
 		//color_index := 255 - uint8(zNormalized * 256)
 
-		m := ani.Simulation.Config.ParticleMass
+		m := particle.Mass
 		colorFormula := float64(particle.Rho / (m * float64(len(ani.Simulation.Root.Particles)*10)) * 256)
 		//colorFormula := float64(particle.Vel.Norm()*256)
 
 		color_index := uint8(math.Min(colorFormula, 255))
-		color := gx.ParaRamp(color_index)
+		color := ani.Simulation.Config.RampOf(species)(color_index)
+		if wall {
+			color = gx.SKYBLUE_OPAQUE
+		}
 		//color := gx.HeatRamp(color_index)
 		//color := gx.ToxicRamp(color_index)
 		//color := gx.RainbowRamp(255 - color_index)
 
 		if color_index > 255 {
-			nnRadius := float32(particle.NNDists[0]) * float32(canvas.W)
+			nnRadius := float32(particle.H) * float32(canvas.W)
 			canvas.DrawCircle(x, y, nnRadius, 2, gx.WHITE)
 		}
 
 		//canvas.DrawDisk(float32(x), float32(y), zNormalized*zNormalized*20+1, color)
 		canvas.DrawDisk(float32(x), float32(y), 4, color)
+
+		// outwards, 10 pixels for |n| = 1
+		if ani.ShowNormals && normal.Norm() > 0.1 {
+			start := gx.Vec2i{X: int(x), Y: int(y)}
+			end := gx.Vec2i{X: int(x - 10*float32(normal.X)), Y: int(y - 10*float32(normal.Y))}
+			canvas.DrawLine(start, end, gx.WHITE)
+		}
 	}
 	return canvas
 }
@@ -106,6 +128,7 @@ func (ani *Animator) CurrentFrame() gx.Canvas {
 func (ani *Animator) Frame() {
 	canvas := ani.CurrentFrame()
 	ani.Frames = append(ani.Frames, canvas.Img)
+	ani.Times = append(ani.Times, ani.Simulation.Time)
 }
 
 func (ani *Animator) FrameToPNG(file_path string, i int) bool {
@@ -130,7 +153,9 @@ func (ani *Animator) FrameToPNG(file_path string, i int) bool {
 }
 
 type Frame struct {
+	Time      float64 // simulated time
 	Positions [][2]float32
+	IDs       []int // to follow a particle across frames
 	NNPos     [2][2]float32
 	Densities []float32
 }
@@ -160,16 +185,24 @@ func (ani *AnimatorGL) AddFrame() {
 	n := len(ani.sim.Root.Particles)
 
 	frame := Frame{
+		Time:      ani.sim.Time,
 		Positions: make([][2]float32, n),
+		IDs:       make([]int, n),
 		Densities: make([]float32, n),
 	}
 
 	for i := range n {
 		p := &ani.sim.Root.Particles[i]
 		frame.Positions[i] = [2]float32{float32(p.Pos.X), float32(p.Pos.Y)}
+		frame.IDs[i] = p.ID
 		frame.Densities[i] = float32(ani.sim.Root.Particles[i].Rho)
-		frame.NNPos[0] = [2]float32{float32(p.NNPos[0].X), float32(p.NNPos[0].Y)}
-		frame.NNPos[1] = [2]float32{float32(p.NNPos[1].X), float32(p.NNPos[1].Y)}
+	}
+
+	// neighbours of the last particle, if they are calculated already
+	if n > 0 && ani.sim.Neighbours.Len() == n && ani.sim.Neighbours.K >= 2 {
+		nn := ani.sim.Neighbours.Of(n - 1)
+		frame.NNPos[0] = [2]float32{float32(nn.Pos[0].X), float32(nn.Pos[0].Y)}
+		frame.NNPos[1] = [2]float32{float32(nn.Pos[1].X), float32(nn.Pos[1].Y)}
 	}
 
 	ani.Frames = append(ani.Frames, frame)
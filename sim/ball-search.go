@@ -0,0 +1,68 @@
+package sim
+
+import (
+	"math"
+)
+
+// Finds all particles within radius r of pos (distance <= r) and appends
+// them to found, in no particular order. Index is into the particles of the
+// root cell, Dists are the real distances. Call it on the top/root Cell.
+//
+// The lists in found can be reused between calls to avoid allocations:
+//
+//	found = root.BallSearch(pos, r, found.Reset())
+func (root *Cell) BallSearch(pos Vec2, r float64, found NNList) NNList {
+	return root.ballSearchRec(pos, r*r, Vec2{0, 0}, found)
+}
+
+// Periodic version, see FindNearestNeighboursPeriodic(). Pos of the found
+// particles is the one of the image closest to pos, so pos - Pos is the
+// right separation. Particles can be found more than once, if r is bigger
+// than half the periodic box.
+func (root *Cell) BallSearchPeriodic(pos Vec2, r float64, HorPeriodic, VertPeriodic [2]float64, found NNList) NNList {
+	offsets, n := periodicOffsets(HorPeriodic, VertPeriodic)
+	for _, offset := range offsets[:n] {
+		// only images where the ball reaches into the root cell
+		image := pos.Add(&offset)
+		if root.DistSquared(&image) <= r*r {
+			found = root.ballSearchRec(pos, r*r, offset, found)
+		}
+	}
+	return found
+}
+
+func (root *Cell) ballSearchRec(pos Vec2, r2 float64, offset Vec2, found NNList) NNList {
+
+	query := pos.Add(&offset)
+
+	// bounding sphere pruning like in findNNRec()
+	distCenter := Dist(root.BCenter, query) - root.BRadius
+	if distCenter > 0 && distCenter*distCenter > r2 {
+		return found
+	}
+
+	if root.Upper == nil && root.Lower == nil {
+		for i := range root.Particles {
+			d2 := DistSq(query, root.Particles[i].Pos)
+			if d2 <= r2 {
+				found.Index = append(found.Index, int32(root.Offset+i))
+				found.Dists = append(found.Dists, math.Sqrt(d2))
+				found.Pos = append(found.Pos, root.Particles[i].Pos.Sub(&offset))
+			}
+		}
+		return found
+	}
+
+	if root.Lower != nil {
+		found = root.Lower.ballSearchRec(pos, r2, offset, found)
+	}
+	if root.Upper != nil {
+		found = root.Upper.ballSearchRec(pos, r2, offset, found)
+	}
+	return found
+}
+
+// Empties the lists, keeping the memory
+func (nn NNList) Reset() NNList {
+	return NNList{nn.Index[:0], nn.Dists[:0], nn.Pos[:0]}
+}
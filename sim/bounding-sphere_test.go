@@ -1,6 +1,7 @@
 package sim
 
 import (
+	"math"
 	"testing"
 )
 
@@ -62,3 +63,112 @@ func TestInsideAnySphere6000(t *testing.T) {
 		}
 	}
 }
+
+func maxLeafSize(cell *Cell) int {
+	if cell.Upper == nil && cell.Lower == nil {
+		return len(cell.Particles)
+	}
+	a, b := 0, 0
+	if cell.Upper != nil {
+		a = maxLeafSize(cell.Upper)
+	}
+	if cell.Lower != nil {
+		b = maxLeafSize(cell.Lower)
+	}
+	return Max(a, b)
+}
+
+func TestInsideAnySphereWideDomain(t *testing.T) {
+
+	spawner := UniformRectSpawner{
+		UpperLeft:  Vec2{-3, 2},
+		LowerRight: Vec2{1, 3},
+		NParticles: 4000,
+	}
+
+	cell := MakeCells(spawner.Spawn(0), Vertical)
+
+	for i, p := range cell.Particles {
+		if p.Pos.X < cell.LowerLeft.X || p.Pos.Y < cell.LowerLeft.Y || p.Pos.X > cell.UpperRight.X || p.Pos.Y > cell.UpperRight.Y {
+			t.Fatalf("Particle %v `%v` is outside of root cell `%v` `%v`!", i, p.Pos, cell.LowerLeft, cell.UpperRight)
+		}
+	}
+
+	if n := maxLeafSize(cell); n > MAX_PARTICLES_PER_CELL {
+		t.Fatalf("Leaf has %v particles, expected at most %v", n, MAX_PARTICLES_PER_CELL)
+	}
+
+	for i, p := range cell.Particles {
+		if !isInsideAny(p.Pos, cell) {
+			t.Fatalf("Particle %v `%v` is not inside any Cell!", i, p.Pos)
+		}
+	}
+}
+
+func TestCoincidentParticles(t *testing.T) {
+
+	particles := make([]Particle, 3*MAX_PARTICLES_PER_CELL)
+	for i := range particles {
+		particles[i].Pos = Vec2{0.3, 0.7}
+	}
+	particles[0].Pos = Vec2{0.1, 0.1}
+
+	cell := MakeCells(particles, Vertical)
+
+	for i, p := range cell.Particles {
+		if !isInsideAny(p.Pos, cell) {
+			t.Fatalf("Particle %v `%v` is not inside any Cell!", i, p.Pos)
+		}
+	}
+}
+
+func TestMakeCellWithShell(t *testing.T) {
+
+	n := 500
+	cell := MakeCellWith(n, func(index int) Particle {
+		phi := 2 * math.Pi * float64(index) / float64(n)
+		return Particle{
+			Pos:  Vec2{2 + math.Cos(phi), -1 + math.Sin(phi)},
+			Vel:  Vec2{-math.Sin(phi), math.Cos(phi)},
+			E:    0.1,
+			Mass: 0.5,
+		}
+	})
+
+	if len(cell.Particles) != n {
+		t.Fatalf("expected %v particles, got %v", n, len(cell.Particles))
+	}
+
+	for i, p := range cell.Particles {
+		if p.Mass != 0.5 || p.E != 0.1 {
+			t.Fatalf("Particle %v lost its initial values: %v", i, p)
+		}
+		if !isInsideAny(p.Pos, cell) {
+			t.Fatalf("Particle %v `%v` is not inside any Cell!", i, p.Pos)
+		}
+	}
+}
+
+func TestMakeCellDefaultMass(t *testing.T) {
+	sim := MakeSimulation()
+	sim.Config.ParticleMass = 2.5
+	sim.Root = MakeCell(200, func(index int) Vec2 {
+		return Vec2{float64(index%20) / 20, float64(index/20) / 10}
+	})
+	for i, p := range sim.Root.Particles {
+		if p.Mass != 0 {
+			t.Fatalf("Particle %v got mass %v before the Simulation", i, p.Mass)
+		}
+	}
+
+	sim.Step()
+
+	for i, p := range sim.Root.Particles {
+		if p.Mass != 2.5 {
+			t.Fatalf("Particle %v has mass %v instead of the configured 2.5", i, p.Mass)
+		}
+		if !(p.Rho > 0) {
+			t.Fatalf("Particle %v has density %v", i, p.Rho)
+		}
+	}
+}
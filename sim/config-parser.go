@@ -24,10 +24,11 @@ import (
 
 // For now these Titles and Subtitles are valid
 var validTitleSubtitles = map[string][]string{
-	"Simulation": {"Config", "Viewport"},
+	"Simulation": {"Config", "Viewport", "Gravity", "Viscosity", "EOS", "SurfaceTension"},
 	"Start":      {"UniformRect"},
-	"Boundaries": {"Periodic", "Reflection"},
+	"Boundaries": {"Periodic", "Reflection", "Wall"},
 	"Sources":    {"Point"},
+	"Species":    {"Fluid"},
 }
 
 type ParticleSource interface {
@@ -38,12 +39,16 @@ type UniformRectSpawner struct {
 	UpperLeft  Vec2
 	LowerRight Vec2
 	NParticles int
+	Mass       float64 // 0 means SphConfig.ParticleMass is used
+	Species    int
 }
 
 type PointSource struct {
 	origin     Vec2
 	rate       float64
 	LastSpwned float64
+	Mass       float64 // 0 means SphConfig.ParticleMass is used
+	Species    int
 }
 
 // sensible defaults
@@ -74,6 +79,7 @@ func (spwn UniformRectSpawner) Spawn(t float64) []Particle {
 	for i := range spwn.NParticles {
 		particles[i].Z = rand.Int()
 		particles[i].E = 0.01
+		particles[i].Mass = spwn.Mass
 	}
 
 	return particles
@@ -96,6 +102,7 @@ func (spwn *PointSource) Spawn(t float64) []Particle {
 		particles[i].Rho = 100
 		particles[i].Z = rand.Int()
 		particles[i].E = 0.002
+		particles[i].Mass = spwn.Mass
 	}
 
 	return particles
@@ -110,19 +117,40 @@ type Reflections struct {
 
 type SphConfig struct {
 	NSteps       int
-	DeltaTHalf   float64
-	Gamma        float64
+	DeltaTHalf   float64 // Half the time step, the upper limit for an adaptive time step
+	EOS          EOS     // Ideal gas per default, see eos.go
 	ParticleMass float64
 	Acceleration Vec2
+	Gravity      Gravity   // Self gravity, off per default
+	Viscosity    Viscosity // Artificial viscosity
 
-	Kernel Kernel
+	SurfaceTension SurfaceTension // Off per default
+
+	Integrator           Integrator // Leapfrog per default, see integrator.go
+	AdaptiveTimeStep     bool       // dt from the Courant and force conditions, see AdaptiveDeltaTHalf()
+	HierarchicalTimeStep bool       // Every particle in its own power-of-two time bin, see time-bins.go
+	TimeBins             int        // Number of time bins, the smallest step is 2 * DeltaTHalf / 2^(TimeBins-1)
+	CourantFactor        float64    // Multiplies h / VSig
+	ForceFactor          float64    // Multiplies sqrt(h / |a|)
+
+	Kernel    Kernel
+	NNSize    int  // Number of nearest neighbours, the mean number with GradH
+	GradH     bool // Solve for H and use the grad-h terms, see grad-h.go
+	Workers   int  // Goroutines for the force calculation, 0 uses all cores
+	TreeSplit SplitStrategy
+
+	ParticleOrder  CurveKey // Sort the particles along this curve before building the tree, nil keeps them
+	TreeRefit      bool     // Refit the tree instead of rebuilding it every time
+	RefitImbalance float64  // Rebuild anyway if the particles of a cell grow or shrink by this factor
 
 	HorPeriodicity  [2]float64 // -math.MaxFloat64, math.MaxFloat64 is open
 	VertPeriodicity [2]float64 // -math.MaxFloat64, math.MaxFloat64 is open
 
 	Reflections Reflections
+	Walls       Walls // Solid walls of boundary particles, see wall.go
 	Sources     []ParticleSource
 	Start       []ParticleSource
+	Species     []Species // Empty for a single fluid, see species.go
 
 	Viewport [2]Vec2 // upperleft and lower right
 }
@@ -130,11 +158,22 @@ type SphConfig struct {
 // default values conifg all valuues are zero or empty arrays except defined in this function:
 func MakeConfig() SphConfig {
 	return SphConfig{
-		Gamma:        1.66666,
+		EOS:          IdealGas{Gamma: 1.66666},
 		NSteps:       10000,
 		DeltaTHalf:   0.001,
-		ParticleMass: 1,
+		ParticleMass: PARTICLE_MASS,
 		Kernel:       Monahan2D,
+		NNSize:       NN_SIZE,
+		TreeSplit:    AlternatingSplit,
+		Gravity:      MakeGravity(),
+		Viscosity:    MakeViscosity(),
+
+		RefitImbalance: 1.2,
+
+		Integrator:    Leapfrog{},
+		TimeBins:      8,
+		CourantFactor: 0.3,
+		ForceFactor:   0.25,
 
 		VertPeriodicity: [2]float64{-math.MaxFloat64, math.MaxFloat64},
 		HorPeriodicity:  [2]float64{-math.MaxFloat64, math.MaxFloat64},
@@ -143,6 +182,7 @@ func MakeConfig() SphConfig {
 			L: -math.MaxFloat64, R: math.MaxFloat64,
 			U: -math.MaxFloat64, D: math.MaxFloat64,
 		},
+		Walls: MakeWalls(),
 
 		Viewport: [2]Vec2{{0, 0}, {1, 1}},
 	}
@@ -212,6 +252,9 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 		if !inSlice(validSubtitles, subtitleStr) {
 			return ConfigMakeError(token, fmt.Sprintf("`%v` is not a valid subtitle under title: `%v`. It's valid subtitles are: %v", subtitleStr, titleStr, validSubtitles))
 		}
+		if subtitleStr == "Fluid" {
+			config.Species = append(config.Species, Species{})
+		}
 
 		if len(tokens) == 0 {
 			break
@@ -231,6 +274,9 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 				if !inSlice(validSubtitles, subtitleStr) {
 					return ConfigMakeError(token, fmt.Sprintf("`%v` is not a valid subtitle under title: `%v`. It's valid subtitles incude: %v", subtitleStr, titleStr, validSubtitles))
 				}
+				if subtitleStr == "Fluid" {
+					config.Species = append(config.Species, Species{})
+				}
 				continue
 			}
 
@@ -251,10 +297,12 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 					return err
 				}
 			case Param{"Simulation", "Config", "Gamma"}:
-				config.Gamma, err = checkFloat(token, p)
+				// old way to set the ideal gas
+				gamma, err := checkFloat(token, p)
 				if err != nil {
 					return err
 				}
+				config.EOS = IdealGas{Gamma: gamma}
 			case Param{"Simulation", "Config", "ParticleMass"}:
 				config.ParticleMass, err = checkFloat(token, p)
 				if err != nil {
@@ -270,14 +318,256 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 				if err != nil {
 					return err
 				}
+			case Param{"Simulation", "Config", "TimeStep"}:
+				timeStep := token.AsStr
+				if timeStep == "Fixed" {
+					config.AdaptiveTimeStep = false
+					config.HierarchicalTimeStep = false
+				} else if timeStep == "Adaptive" {
+					config.AdaptiveTimeStep = true
+					config.HierarchicalTimeStep = false
+				} else if timeStep == "Hierarchical" {
+					config.AdaptiveTimeStep = false
+					config.HierarchicalTimeStep = true
+				} else {
+					return ConfigMakeError(token, fmt.Sprintf("TimeStep `%v` is not implemented. Choose one of `Fixed, Adaptive, Hierarchical`", timeStep))
+				}
+			case Param{"Simulation", "Config", "Integrator"}:
+				integrator, ok := Integrators[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("Integrator `%v` is not implemented. Choose one of `Leapfrog, VelocityVerlet, RungeKutta, SemiImplicitEuler`", token.AsStr))
+				}
+				config.Integrator = integrator
+			case Param{"Simulation", "Config", "TimeBins"}:
+				config.TimeBins, err = checkInt(token, p)
+				if err != nil {
+					return err
+				}
+				if config.TimeBins < 1 || config.TimeBins > 30 {
+					return ConfigMakeError(token, fmt.Sprintf("TimeBins needs to be between 1 and 30 but is %v", config.TimeBins))
+				}
+			case Param{"Simulation", "Config", "CourantFactor"}:
+				config.CourantFactor, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Config", "ForceFactor"}:
+				config.ForceFactor, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
 			case Param{"Simulation", "Config", "Kernel"}:
-				kernel := token.AsStr
-				if kernel == "Monahan" {
-					config.Kernel = Monahan2D
-				} else if kernel == "Wendtland" {
-					config.Kernel = Wendtland2D
+				kernel, ok := Kernels[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("Kernel `%v` is not implemented. Choose one of `M4, M5, M6, WendlandC2, WendlandC4, WendlandC6, Gaussian`", token.AsStr))
+				}
+				config.Kernel = kernel
+
+			case Param{"Simulation", "Config", "NNSize"}:
+				config.NNSize, err = checkInt(token, p)
+				if err != nil {
+					return err
+				}
+				if config.NNSize < 2 {
+					return ConfigMakeError(token, fmt.Sprintf("NNSize needs to be at least 2 but is %v", config.NNSize))
+				}
+			case Param{"Simulation", "Config", "SmoothingLength"}:
+				smoothing := token.AsStr
+				if smoothing == "Neighbours" {
+					config.GradH = false
+				} else if smoothing == "GradH" {
+					config.GradH = true
+				} else {
+					return ConfigMakeError(token, fmt.Sprintf("SmoothingLength `%v` is not implemented. Choose one of `Neighbours, GradH`", smoothing))
+				}
+
+			case Param{"Simulation", "Config", "Workers"}:
+				config.Workers, err = checkInt(token, p)
+				if err != nil {
+					return err
+				}
+				if config.Workers < 0 {
+					return ConfigMakeError(token, fmt.Sprintf("Workers can't be negative but is %v", config.Workers))
+				}
+
+			case Param{"Simulation", "Config", "TreeSplit"}:
+				split, ok := SplitStrategies[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("TreeSplit `%v` is not implemented. Choose one of `Alternating, LongestAxis, Median, Variance`", token.AsStr))
+				}
+				config.TreeSplit = split
+
+			case Param{"Simulation", "Config", "ParticleOrder"}:
+				order, ok := ParticleOrders[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("ParticleOrder `%v` is not implemented. Choose one of `None, Morton, Hilbert`", token.AsStr))
+				}
+				config.ParticleOrder = order
+
+			case Param{"Simulation", "Config", "TreeUpdate"}:
+				update := token.AsStr
+				if update == "Rebuild" {
+					config.TreeRefit = false
+				} else if update == "Refit" {
+					config.TreeRefit = true
+				} else {
+					return ConfigMakeError(token, fmt.Sprintf("TreeUpdate `%v` is not implemented. Choose one of `Rebuild, Refit`", update))
+				}
+			case Param{"Simulation", "Config", "RefitImbalance"}:
+				config.RefitImbalance, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+
+			case Param{"Simulation", "Viscosity", "Alpha"}:
+				config.Viscosity.Alpha, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Viscosity", "Beta"}:
+				config.Viscosity.Beta, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Viscosity", "EtaSq"}:
+				config.Viscosity.EtaSq, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Viscosity", "Switch"}:
+				viscSwitch, ok := ViscositySwitches[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("Switch `%v` is not implemented. Choose one of `None, MorrisMonaghan, CullenDehnen`", token.AsStr))
+				}
+				config.Viscosity.Switch = viscSwitch
+			case Param{"Simulation", "Viscosity", "AlphaMin"}:
+				config.Viscosity.AlphaMin, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Viscosity", "AlphaMax"}:
+				config.Viscosity.AlphaMax, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Viscosity", "Decay"}:
+				config.Viscosity.Decay, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Viscosity", "Limiter"}:
+				limiter := token.AsStr
+				if limiter == "None" {
+					config.Viscosity.Balsara = false
+				} else if limiter == "Balsara" {
+					config.Viscosity.Balsara = true
+				} else {
+					return ConfigMakeError(token, fmt.Sprintf("Limiter `%v` is not implemented. Choose one of `None, Balsara`", limiter))
+				}
+			case Param{"Simulation", "Viscosity", "Mu"}:
+				config.Viscosity.Mu, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				if config.Viscosity.Mu < 0 {
+					return ConfigMakeError(token, "Mu has to be positive or 0")
+				}
+			case Param{"Simulation", "EOS", "Type"}:
+				eos, ok := EOSs[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("EOS `%v` is not implemented. Choose one of `IdealGas, Isothermal, Polytropic, Tait`", token.AsStr))
+				}
+				config.EOS = eos
+			case Param{"Simulation", "EOS", "Gamma"},
+				Param{"Simulation", "EOS", "SoundSpeed"},
+				Param{"Simulation", "EOS", "K"},
+				Param{"Simulation", "EOS", "RestDensity"},
+				Param{"Simulation", "EOS", "BackgroundPressure"}:
+				value, err := checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				eos, ok := setEOSParam(config.EOS, token.Name, value)
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("`%v` is not a parameter of the EOS %T, set the Type first", token.Name, config.EOS))
+				}
+				config.EOS = eos
+			case Param{"Simulation", "SurfaceTension", "Tension"}:
+				config.SurfaceTension.Tension, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				if config.SurfaceTension.Tension < 0 {
+					return ConfigMakeError(token, "Tension has to be positive or 0")
+				}
+			case Param{"Simulation", "SurfaceTension", "RestDensity"}:
+				config.SurfaceTension.Rho0, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Species", "Fluid", "EOS"}:
+				eos, ok := EOSs[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("EOS `%v` is not implemented. Choose one of `IdealGas, Isothermal, Polytropic, Tait`", token.AsStr))
+				}
+				config.Species[len(config.Species)-1].EOS = eos
+			case Param{"Species", "Fluid", "Gamma"},
+				Param{"Species", "Fluid", "SoundSpeed"},
+				Param{"Species", "Fluid", "K"},
+				Param{"Species", "Fluid", "RestDensity"},
+				Param{"Species", "Fluid", "BackgroundPressure"}:
+				species := &config.Species[len(config.Species)-1]
+				value, err := checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				eos, ok := setEOSParam(species.EOS, token.Name, value)
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("`%v` is not a parameter of the EOS %T, set the EOS first", token.Name, species.EOS))
+				}
+				species.EOS = eos
+			case Param{"Species", "Fluid", "Mu"}:
+				species := &config.Species[len(config.Species)-1]
+				species.Mu, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				if species.Mu < 0 {
+					return ConfigMakeError(token, "Mu has to be positive or 0")
+				}
+			case Param{"Species", "Fluid", "Color"}:
+				ramp, ok := ColorRamps[token.AsStr]
+				if !ok {
+					return ConfigMakeError(token, fmt.Sprintf("Color `%v` is not implemented. Choose one of `Para, Heat, Toxic, Rainbow`", token.AsStr))
+				}
+				config.Species[len(config.Species)-1].Ramp = ramp
+			case Param{"Simulation", "Gravity", "G"}:
+				config.Gravity.G, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Gravity", "Theta"}:
+				config.Gravity.Theta, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				// from 1 on a cell can be taken as a whole by a particle inside it
+				if config.Gravity.Theta <= 0 || config.Gravity.Theta >= 1 {
+					return ConfigMakeError(token, fmt.Sprintf("Theta needs to be between 0 and 1 but is %v", config.Gravity.Theta))
+				}
+			case Param{"Simulation", "Gravity", "Softening"}:
+				config.Gravity.Softening, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Simulation", "Gravity", "Expansion"}:
+				expansion := token.AsStr
+				if expansion == "Monopole" {
+					config.Gravity.Quadrupole = false
+				} else if expansion == "Quadrupole" {
+					config.Gravity.Quadrupole = true
 				} else {
-					return ConfigMakeError(token, fmt.Sprintf("Kernel `%v` is not implemented", kernel))
+					return ConfigMakeError(token, fmt.Sprintf("Expansion `%v` is not implemented. Choose one of `Monopole, Quadrupole`", expansion))
 				}
 
 			case Param{"Simulation", "Viewport", "UpperLeft"}:
@@ -335,12 +625,23 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 					token, tokens = tokens[0], tokens[1:]
 				}
 
-				if tokens[0].Type != title && tokens[0].Type != subtitle {
-					return ConfigMakeError(token, fmt.Sprintf("The 4th parameter name `%v` in [`%v`] is too much. need to have `NParticles, UpperLeft and LowerRight`", token.Name, subtitleStr))
+				startSpawner.Mass, startSpawner.Species, tokens, err = spawnerExtras(tokens, p)
+				if err != nil {
+					return err
+				}
+
+				if len(tokens) > 0 && tokens[0].Type != title && tokens[0].Type != subtitle {
+					return ConfigMakeError(tokens[0], fmt.Sprintf("The parameter `%v` in [`%v`] is too much. need to have `NParticles, UpperLeft and LowerRight` and optionally `Mass, Species`", tokens[0].Name, subtitleStr))
 				}
 
 				config.Start = append(config.Start, startSpawner)
 
+			case Param{"Start", "UniformRect", "Mass"},
+				Param{"Start", "UniformRect", "Species"},
+				Param{"Sources", "Point", "Mass"},
+				Param{"Sources", "Point", "Species"}:
+				return ConfigMakeError(token, fmt.Sprintf("`%v` has to come after the other parameters of [`%v`]", token.Name, subtitleStr))
+
 			case Param{"Boundaries", "Periodic", "Vertical"}:
 				// TODO: make somehow sure left < right and so on
 				x, err := checkVec2(token, p)
@@ -381,6 +682,71 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 				}
 				config.Reflections.D = x
 
+			case Param{"Boundaries", "Wall", "Left"}:
+				config.Walls.Planes.L, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "Right"}:
+				config.Walls.Planes.R, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "Up"}:
+				config.Walls.Planes.U, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "Down"}:
+				config.Walls.Planes.D, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "LeftVelocity"}:
+				config.Walls.Velocity.L, err = checkVec2(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "RightVelocity"}:
+				config.Walls.Velocity.R, err = checkVec2(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "UpVelocity"}:
+				config.Walls.Velocity.U, err = checkVec2(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "DownVelocity"}:
+				config.Walls.Velocity.D, err = checkVec2(token, p)
+				if err != nil {
+					return err
+				}
+			case Param{"Boundaries", "Wall", "Spacing"}:
+				config.Walls.Spacing, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				if config.Walls.Spacing <= 0 {
+					return ConfigMakeError(token, "Spacing has to be positive")
+				}
+			case Param{"Boundaries", "Wall", "Layers"}:
+				config.Walls.Layers, err = checkInt(token, p)
+				if err != nil {
+					return err
+				}
+				if config.Walls.Layers < 1 {
+					return ConfigMakeError(token, "Layers has to be at least 1")
+				}
+			case Param{"Boundaries", "Wall", "Mass"}:
+				config.Walls.Mass, err = checkFloat(token, p)
+				if err != nil {
+					return err
+				}
+				if config.Walls.Mass <= 0 {
+					return ConfigMakeError(token, "Mass has to be positive")
+				}
+
 			case Param{"Sources", "Point", "Pos"},
 				Param{"Sources", "Point", "Rate"}:
 
@@ -419,6 +785,10 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 					rate:   rate,
 					origin: pos,
 				}
+				pointSource.Mass, pointSource.Species, tokens, err = spawnerExtras(tokens, p)
+				if err != nil {
+					return err
+				}
 
 				config.Sources = append(config.Sources, pointSource)
 
@@ -428,9 +798,71 @@ func (config *SphConfig) updateFromTokens(tokens []Token) error {
 		}
 	}
 
+	if err := config.checkViscosity(); err != nil {
+		return err
+	}
+	return config.checkSpecies()
+}
+
+// Optional Mass and Species of a spawner, they follow its other parameters
+func spawnerExtras(tokens []Token, p Param) (mass float64, species int, rest []Token, err error) {
+	for len(tokens) > 0 && tokens[0].Type != title && tokens[0].Type != subtitle {
+		token := tokens[0]
+		switch token.Name {
+		case "Mass":
+			mass, err = checkFloat(token, p)
+			if err == nil && mass < 0 {
+				err = ConfigMakeError(token, "Mass has to be positive, or 0 for the ParticleMass")
+			}
+		case "Species":
+			species, err = checkInt(token, p)
+			if err == nil && species < 0 {
+				err = ConfigMakeError(token, "Species has to be positive")
+			}
+		default:
+			return mass, species, tokens, nil
+		}
+		if err != nil {
+			return mass, species, tokens, err
+		}
+		tokens = tokens[1:]
+	}
+	return mass, species, tokens, nil
+}
+
+// AlphaMin and AlphaMax can come in any order
+func (config *SphConfig) checkViscosity() error {
+	visc := &config.Viscosity
+	if visc.AlphaMax < visc.AlphaMin {
+		return fmt.Errorf("ConfigMakeError: AlphaMax %v in [Viscosity] is below AlphaMin %v", visc.AlphaMax, visc.AlphaMin)
+	}
 	return nil
 }
 
+// The species of the spawners have to exist, they can be defined after them
+func (config *SphConfig) checkSpecies() error {
+	n := max(len(config.Species), 1)
+	spawners := append(append([]ParticleSource{}, config.Start...), config.Sources...)
+	for _, spawner := range spawners {
+		species := spawnerSpecies(spawner)
+		if species >= n {
+			return fmt.Errorf("ConfigMakeError: Species %v of a spawner is not defined, there are %v [Fluid]s in [[Species]]", species, len(config.Species))
+		}
+	}
+	return nil
+}
+
+// Species of the particles of a spawner
+func spawnerSpecies(spawner ParticleSource) int {
+	switch s := spawner.(type) {
+	case UniformRectSpawner:
+		return s.Species
+	case *PointSource:
+		return s.Species
+	}
+	return 0
+}
+
 func checkInt(t Token, p Param) (int, error) {
 	if t.Type != integer {
 		return 0, ConfigMakeError(t, fmt.Sprintf("expected an integer but got something else"))
@@ -656,9 +1088,9 @@ func Tokenize(fname string) (error, []Token) {
 				}
 
 			} else if t.is(aLetter) { // parse a word
-				supposedWord := string(t.chopUntilIsNoFail(notALetter))
+				supposedWord := string(t.chopUntilIsNoFail(notALetterOrDigit))
 				if len(supposedWord) == 0 || !t.is(unicode.IsSpace) {
-					msg := fmt.Sprintf("`%v` Excpected only Letters and a whitespace at the end to form a Word", string(supposedWord))
+					msg := fmt.Sprintf("`%v` Excpected only Letters, Digits and a whitespace at the end to form a Word", string(supposedWord))
 					return ConfigParseError(t, msg), tokens
 				}
 
@@ -709,6 +1141,10 @@ func notALetter(c rune) bool {
 	return !(('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'))
 }
 
+func notALetterOrDigit(c rune) bool {
+	return notALetter(c) && notADigit(c)
+}
+
 func aDigit(c rune) bool {
 	return ('0' <= c && c <= '9')
 }
@@ -878,21 +1314,111 @@ var exampleConfigSource1 = `//  Config file for SPHUGO SPH Simulation
 [[Simulation]]
 [Config]
 NSteps              1000
-Gamma               4.666
 ParticleMass        1000000.0
 // A 2-D Vector just has 2 components separated by space(s)
 Acceleration        0       0.55
 DeltaTHalf          0.00324
-//Kernel            Monahan
-Kernel              Wendtland
+// Fixed uses DeltaTHalf, Adaptive limits it further with the Courant condition
+// CourantFactor * h / v_signal and the force condition ForceFactor * sqrt(h / |a|)
+// Hierarchical gives every particle its own step 2*DeltaTHalf / 2^n from the
+// same conditions, with n < TimeBins
+TimeStep            Fixed
+TimeBins            8
+// Leapfrog, VelocityVerlet, SemiImplicitEuler (symplectic) or RungeKutta (2nd order, not symplectic)
+Integrator          Leapfrog
+CourantFactor       0.3
+ForceFactor         0.25
+// M4 (old name Monahan), M5, M6, WendlandC2 (Wendtland), WendlandC4, WendlandC6
+// or Gaussian (truncated at 3 sigma)
+//Kernel            M4
+Kernel              WendlandC2
+// Number of nearest neighbours, the Wendlands like more than M4
+NNSize              32
+// Neighbours: the kernel support is the distance to the NNSize-th neighbour
+// GradH: solved from rho h^2 = NNSize m / pi, with the grad-h terms in the forces
+SmoothingLength     Neighbours
+// Goroutines for the neighbour search and forces, 0 uses all cores
+Workers             0
+// How tree cells are split: Alternating, LongestAxis, Median or Variance
+TreeSplit           Alternating
+// Sort the particles along a space filling curve before the tree is built,
+// for better cache use: None, Morton or Hilbert
+ParticleOrder       None
+//ParticleOrder     Morton
+// Rebuild the tree every step, or Refit it: the particles that left their leaf
+// are moved to their new one, the splits stay. It is rebuilt anyway if the
+// particles of a cell grew or shrank by more than the factor RefitImbalance
+TreeUpdate          Rebuild
+//TreeUpdate        Refit
+RefitImbalance      1.2
+
+// Artificial viscosity, Alpha and Beta are the linear and quadratic terms.
+// Switch MorrisMonaghan or CullenDehnen gives every particle its own alpha
+// between AlphaMin and AlphaMax (not below AlphaMin), decaying over
+// H / (Decay c), also with Alpha 0.
+// Limiter Balsara turns the viscosity down in shear flows.
+// Mu is the dynamic viscosity of a physical (Navier-Stokes) term for liquids,
+// 0 turns it off.
+[Viscosity]
+Alpha               0.75
+Beta                1.5
+EtaSq               0.01
+Switch              None
+AlphaMin            0.05
+AlphaMax            1.5
+Decay               0.2
+Limiter             None
+Mu                  0
+
+// Equation of state for the pressure and the speed of sound:
+// IdealGas (Gamma), Isothermal (SoundSpeed), Polytropic (K, Gamma) or
+// Tait (RestDensity, SoundSpeed, Gamma, BackgroundPressure) for water.
+// Type comes first, the parameters of the other types are not allowed.
+[EOS]
+Type                IdealGas
+Gamma               4.666
+
+// Surface tension for liquids (Akinci et al. 2013), Tension 0 turns it off.
+// RestDensity makes up for the missing neighbours at the surface, best
+// together with the same RestDensity of a Tait EOS.
+//[SurfaceTension]
+//Tension           0.5
+//RestDensity       1000
+
+// Self gravity with the Barnes-Hut tree, G 0 turns it off (the default).
+// Cells are opened if they are seen under an angle larger than Theta, which
+// has to be between 0 and 1
+//[Gravity]
+//G                 0.001
+//Theta             0.5
+//Softening         0.01
+//Expansion         Quadrupole
+
+// Several fluids, every [Fluid] is a species with its own EOS (and its
+// parameters like in [EOS]), physical viscosity Mu and Color ramp
+// (Para, Heat, Toxic, Rainbow). The first one is species 0. Without species
+// all particles use [EOS] and the Mu of [Viscosity].
+//[[Species]]
+//[Fluid]
+//EOS               IdealGas
+//Gamma             4.666
+//Color             Para
+//[Fluid]
+//EOS               IdealGas
+//Gamma             1.4
+//Mu                0.001
+//Color             Heat
 
 // Initial setup of particles, for now we can add Uniformely Random distributed Rectangels only
 [[Start]]
 
+// Mass (0 is the ParticleMass) and Species are optional and come last
 [UniformRect]
 NParticles          260
 UpperLeft           0.6     0.2
 LowerRight          0.79    0.3
+//Mass              2000000.0
+//Species           1
 
 [UniformRect]
 NParticles          700
@@ -910,6 +1436,20 @@ Vertical            -100      100
 [Reflection]
 Down                0.99
 
+// Solid walls of fixed boundary particles outside of the planes, their
+// pressure follows the fluid so they hold it at rest. Spacing and Mass
+// default to the densest [UniformRect], Layers to the kernel support. The
+// walls can slide along with a velocity for the physical viscosity, e.g.
+// UpVelocity for a lid-driven cavity
+//[Wall]
+//Left              0.2
+//Right             0.8
+//Down              0.99
+//DownVelocity      0.1     0
+//Spacing           0.01
+//Layers            4
+//Mass              100000.0
+
 //[[Sources]]
 //[Point]
 //Pos               0.21     0.21
@@ -935,8 +1475,8 @@ ParticleMass        100000.0
 // A 2-D Vector just has 2 components separated by space(s)
 Acceleration        0       0.05
 DeltaTHalf          0.00424
-//Kernel		    Monahan
-Kernel				Wendtland
+//Kernel		    M4
+Kernel				WendlandC2
 
 // Initial setup of particles, for now we can add Uniformely Random distributed Rectangels only
 [[Start]]
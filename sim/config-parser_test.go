@@ -0,0 +1,48 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExampleConfigs(t *testing.T) {
+	dir := t.TempDir()
+	paths := [2]string{filepath.Join(dir, "example.sph-config"), filepath.Join(dir, "tube.sph-config")}
+	GenerateDefaultConfigFiles(paths)
+
+	for _, path := range paths {
+		if _, err := MakeConfigFromFile(path); err != nil {
+			t.Fatalf("example config %v does not parse: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestViscosityConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "viscosity.conf")
+	source := "[[Simulation]]\n[Viscosity]\nAlpha 1\nBeta 2\nSwitch MorrisMonaghan\nAlphaMin 0.1\nLimiter Balsara\nMu 0.5\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := MakeConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("could not parse viscosity config: %v", err)
+	}
+	visc := conf.Viscosity
+	if visc.Alpha != 1 || visc.Beta != 2 || visc.Switch != MorrisMonaghan || visc.AlphaMin != 0.1 || !visc.Balsara || visc.Mu != 0.5 {
+		t.Fatalf("viscosity config not applied: %+v", visc)
+	}
+	if visc.EtaSq != MakeViscosity().EtaSq {
+		t.Fatalf("EtaSq should keep its default, got %v", visc.EtaSq)
+	}
+
+	// AlphaMax can't be below AlphaMin
+	source = "[[Simulation]]\n[Viscosity]\nAlphaMax 0.5\nAlphaMin 1\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeConfigFromFile(path); err == nil {
+		t.Fatalf("AlphaMax below AlphaMin should not be accepted")
+	}
+}
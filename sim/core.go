@@ -11,31 +11,36 @@ const (
 	MAX_PARTICLES_PER_CELL = 8
 	SPLIT_FRACTION         = 0.5   // Fraction of left to total space for Treebuild(), usually 0.5.
 	USE_RANDOM_SEED        = false // for generating randomly distributed particles in init_uniformly()
-	NN_SIZE                = 32    // Nearest Neighbour Size
+	NN_SIZE                = 32    // Default Nearest Neighbour Size, see SphConfig.NNSize
+	NN_HINT_FACTOR         = 1.2   // First search radius relative to the last one
+	REFIT_MIN_PARTICLES    = 64    // Smallest cell checked by Imbalance()
+	PARTICLE_MASS          = 1.0   // Default SphConfig.ParticleMass
 )
 
+// The fields of the optional features are kept outside of the particles in
+// ParticleState, see particle-state.go
 type Particle struct {
-	Pos Vec2
+	// read by the tree walks and the density loop, kept together
+	Pos  Vec2
+	H    float64 // Kernel support, the distance to the farthest nearest neighbour or solved with grad-h
+	Mass float64 // 0 means SphConfig.ParticleMass is used
+	Rho  float64 // Density
+
 	Vel Vec2
-	Rho float64 // Density
-	C   float64 // Speed of sound
 	E   float64 // Specific internal energy
+	P   float64 // Pressure
+	C   float64 // Speed of sound
+	ID  int     // Persistent, assigned by the Simulation starting at 1, 0 means not assigned yet
 
 	// Temporary values filled by Simulation
-	EDot  float64 // specific internal energy change de/dt
 	VDot  Vec2    // Acceleration
-	EPred float64 // Predicted internal energy
+	EDot  float64 // specific internal energy change de/dt
 	VPred Vec2    // Predicted Velicty
-	// 96 bytes until now
-
-	// TODO: move this out of particles so we have smaller particle size! -> cache locality
-	// @Speed might be bad because we have a big particle size now
-	// should rather keep it seperate to prevent cache misses?
-	// for now we just naively implement it like this
-	// 32*(8+8)  = 512 bytes
-	NearestNeighbours [NN_SIZE]*Particle
-	NNDists           [NN_SIZE]float64
-	NNPos             [NN_SIZE]Vec2 // keep track of position,  we need to know because of periodic b.c.
+	EPred float64 // Predicted internal energy
+	Omega float64 // grad-h correction, 1 without it
+	VSig  float64 // Signal speed for the time step
+	Bin   int     // Time bin with hierarchical time steps, the step is 2 * DeltaTHalf / 2^Bin
+	// nearest neighbours are kept outside in Neighbours -> cache locality
 
 	// visualisation trick for depth rendering
 	Z int
@@ -45,6 +50,7 @@ type Particle struct {
 // at most MAX_PARTICLES_PER_CELL
 type Cell struct {
 	Particles []Particle
+	Offset    int // index of Particles[0] in the particles of the root cell
 
 	// Bounds of Cell
 	LowerLeft  Vec2
@@ -54,9 +60,30 @@ type Cell struct {
 	BCenter Vec2
 	BRadius float64
 
+	// Multipole moments for gravity, see Moments()
+	Mass float64
+	CoM  Vec2       // Centre of mass
+	Quad [3]float64 // Traceless quadrupole xx, xy, yy about CoM
+
+	HMax float64 // Largest H of the particles, see UpdateHMax()
+
 	// Children
 	Lower *Cell
 	Upper *Cell
+
+	// Orientation suggested to the SplitStrategy when this cell was built
+	Orientation Orientation
+
+	// How the SplitStrategy divided the cell, kept for Refit()
+	SplitAxis Orientation
+	SplitAt   float64
+
+	// Number of particles when the cell was built, see Imbalance()
+	built int
+
+	// set by Refit(): the bounding sphere is outdated / a particle crossed the split
+	moved   bool
+	crossed bool
 }
 
 type Orientation int
@@ -90,20 +117,51 @@ func InitUniformly(particles []Particle) {
 	}
 }
 
+// Builds the tree with a root cell that is just big enough to hold all
+// particles, so any world coordinates work (not only [0, 1] x [0, 1]).
 func MakeCells(particles []Particle, ori Orientation) *Cell {
+	lowerLeft, upperRight := ParticleBounds(particles)
+	return MakeCellsInBox(particles, ori, AlternatingSplit, lowerLeft, upperRight)
+}
+
+// Builds the tree with a root cell spanning at least lowerLeft to upperRight,
+// e.g. the periodic or reflection limits of the simulation. The box is grown
+// if some particles lie outside of it. The cells are divided according to split.
+func MakeCellsInBox(particles []Particle, ori Orientation, split SplitStrategy, lowerLeft, upperRight Vec2) *Cell {
+
+	bLowerLeft, bUpperRight := ParticleBounds(particles)
 
 	root := Cell{
-		LowerLeft:  Vec2{0, 0},
-		UpperRight: Vec2{1, 1},
+		LowerLeft:  Vec2{math.Min(lowerLeft.X, bLowerLeft.X), math.Min(lowerLeft.Y, bLowerLeft.Y)},
+		UpperRight: Vec2{math.Max(upperRight.X, bUpperRight.X), math.Max(upperRight.Y, bUpperRight.Y)},
 		Particles:  particles[:],
 	}
 
-	root.Treebuild(ori)
+	root.TreebuildWith(split, ori)
 	root.BoundingSpheres()
 
 	return &root
 }
 
+// Axis aligned bounding box of the particles.
+// For no particles the unit square is returned.
+func ParticleBounds(particles []Particle) (lowerLeft, upperRight Vec2) {
+	if len(particles) == 0 {
+		return Vec2{0, 0}, Vec2{1, 1}
+	}
+
+	lowerLeft = particles[0].Pos
+	upperRight = particles[0].Pos
+	for i := range particles {
+		pos := &particles[i].Pos
+		lowerLeft.X = math.Min(lowerLeft.X, pos.X)
+		lowerLeft.Y = math.Min(lowerLeft.Y, pos.Y)
+		upperRight.X = math.Max(upperRight.X, pos.X)
+		upperRight.Y = math.Max(upperRight.Y, pos.Y)
+	}
+	return lowerLeft, upperRight
+}
+
 func MakeCellsUniform(n int, orientation Orientation) *Cell {
 
 	particles := make([]Particle, n)
@@ -113,8 +171,23 @@ func MakeCellsUniform(n int, orientation Orientation) *Cell {
 	return cell
 }
 
+// Builds the tree for numberParticles particles at positions given by
+// initalizer. All other values of the particles are zero.
 func MakeCell(numberParticles int, initalizer func(index int) Vec2) (root *Cell) {
-	panic("Not Implemented")
+	return MakeCellWith(numberParticles, func(index int) Particle {
+		return Particle{Pos: initalizer(index)}
+	})
+}
+
+// Builds the tree for numberParticles particles, each one is fully set up by
+// initalizer (position, velocity, internal energy, mass, ...). The returned
+// root has its bounding spheres calculated and can be used as Simulation.Root.
+func MakeCellWith(numberParticles int, initalizer func(index int) Particle) (root *Cell) {
+	particles := make([]Particle, numberParticles)
+	for i := range particles {
+		particles[i] = initalizer(i)
+	}
+	return MakeCells(particles, Vertical)
 }
 
 /* The function Partition() partitions an array of type Particle based
@@ -139,9 +212,9 @@ func Partition(ps []Particle, orientation Orientation, middle float64) (a, b []P
 			if ps[i].Pos.Y > ps[j].Pos.Y {
 				ps[i], ps[j] = ps[j], ps[i]
 			}
-			if i == j && middle > ps[i].Pos.Y {
-				i++
-			}
+		}
+		if i == j && middle >= ps[i].Pos.Y {
+			i++
 		}
 	} else {
 		for i < j {
@@ -156,7 +229,7 @@ func Partition(ps []Particle, orientation Orientation, middle float64) (a, b []P
 				ps[i], ps[j] = ps[j], ps[i]
 			}
 		}
-		if i == j && middle > ps[i].Pos.X {
+		if i == j && middle >= ps[i].Pos.X {
 			i++
 		}
 	}
@@ -170,27 +243,38 @@ The SPLIT_FRACTION determines the fraction of space in
 the specific direction for left/total or top/total. */
 
 func (root *Cell) Treebuild(orientation Orientation) {
+	root.TreebuildWith(AlternatingSplit, orientation)
+}
 
-	// dirty fix: particles out of [0, 1] x [0, 1] will grow cells indefinitely
-	// MAX_PARTICLES_PER_CELL not satisfied...
-	if root.LowerLeft.Y == root.UpperRight.Y && root.LowerLeft.X == root.UpperRight.X {
-		return
-	}
+// Same as Treebuild() but the cells are divided according to split,
+// see tree-split.go for the available strategies.
+func (root *Cell) TreebuildWith(split SplitStrategy, orientation Orientation) {
 
-	var mid float64
-	if orientation == Vertical {
-		mid = SPLIT_FRACTION*root.LowerLeft.Y + (1-SPLIT_FRACTION)*root.UpperRight.Y
-	} else {
-		mid = SPLIT_FRACTION*root.LowerLeft.X + (1-SPLIT_FRACTION)*root.UpperRight.X
-	}
+	root.Orientation = orientation
+	root.Lower = nil
+	root.Upper = nil
+	root.built = len(root.Particles)
+
+	orientation, mid := split(root, orientation)
+	root.SplitAxis = orientation
+	root.SplitAt = mid
 
 	a, b := Partition(root.Particles, orientation, mid)
 
+	// particles on top of each other can't be separated by any split,
+	// they stay together in this cell even if MAX_PARTICLES_PER_CELL is exceeded
+	if (len(a) == 0 || len(b) == 0) && coincident(root.Particles) {
+		return
+	}
+
 	if len(a) > 0 {
 		root.Lower = &Cell{
-			Particles:  a,
-			LowerLeft:  root.LowerLeft,
-			UpperRight: root.UpperRight,
+			Particles:   a,
+			Offset:      root.Offset,
+			built:       len(a),
+			LowerLeft:   root.LowerLeft,
+			UpperRight:  root.UpperRight,
+			Orientation: orientation.other(),
 		}
 
 		if orientation == Vertical {
@@ -200,15 +284,18 @@ func (root *Cell) Treebuild(orientation Orientation) {
 		}
 
 		if len(a) > MAX_PARTICLES_PER_CELL {
-			root.Lower.Treebuild(orientation.other())
+			root.Lower.TreebuildWith(split, orientation.other())
 		}
 	}
 
 	if len(b) > 0 {
 		root.Upper = &Cell{
-			Particles:  b,
-			LowerLeft:  root.LowerLeft,
-			UpperRight: root.UpperRight,
+			Particles:   b,
+			Offset:      root.Offset + len(a),
+			built:       len(b),
+			LowerLeft:   root.LowerLeft,
+			UpperRight:  root.UpperRight,
+			Orientation: orientation.other(),
 		}
 
 		if orientation == Vertical {
@@ -218,11 +305,266 @@ func (root *Cell) Treebuild(orientation Orientation) {
 		}
 
 		if len(b) > MAX_PARTICLES_PER_CELL {
-			root.Upper.Treebuild(orientation.other())
+			root.Upper.TreebuildWith(split, orientation.other())
+		}
+	}
+}
+
+/* The function Refit() updates the tree after the particles moved a bit,
+instead of building it from scratch. The cells keep their bounds and splits.
+A particle that left its leaf is moved to the leaf it is in now, only the
+smallest cell holding both leaves is rearranged and the particles that stayed
+keep their order. Leaves that get more than MAX_PARTICLES_PER_CELL are split
+with split, emptied ones are removed and cells that are left with at most
+MAX_PARTICLES_PER_CELL particles become leaves again. The bounding spheres are
+only recalculated for the leaves that changed or have a particle outside of
+their sphere and merged up along their paths.
+
+Returns false if particles left the root cell, then the tree has to be
+rebuilt with Treebuild(). */
+
+func (root *Cell) Refit(split SplitStrategy) bool {
+	if escaped := root.markMoved(root.Particles, nil); len(escaped) > 0 {
+		return false
+	}
+	root.refit(split)
+	root.updateSpheres()
+	return true
+}
+
+// Marks the cells with a particle that crossed their split and the ones with
+// an outdated bounding sphere below. Returns the particles that left the cell
+// as indices into all, the particles of the root.
+func (cell *Cell) markMoved(all []Particle, escaped []int) []int {
+	cell.moved = false
+	cell.crossed = false
+
+	if cell.Upper == nil && cell.Lower == nil {
+		r2 := cell.BRadius * cell.BRadius
+		for i := range cell.Particles {
+			pos := &cell.Particles[i].Pos
+			if !cell.contains(pos) {
+				escaped = append(escaped, cell.Offset+i)
+				cell.moved = true
+			} else if DistSq(*pos, cell.BCenter) > r2 {
+				cell.moved = true
+			}
+		}
+		return escaped
+	}
+
+	start := len(escaped)
+	for _, child := range [2]*Cell{cell.Lower, cell.Upper} {
+		if child != nil {
+			escaped = child.markMoved(all, escaped)
+			cell.moved = cell.moved || child.moved
+		}
+	}
+
+	// the ones still inside went from one side of the split to the other
+	kept := start
+	for _, i := range escaped[start:] {
+		if cell.contains(&all[i].Pos) {
+			cell.crossed = true
+		} else {
+			escaped[kept] = i
+			kept++
+		}
+	}
+	return escaped[:kept]
+}
+
+func (cell *Cell) refit(split SplitStrategy) {
+	if !cell.moved {
+		return
+	}
+	if cell.crossed {
+		cell.moveCrossed(split)
+		return
+	}
+	for _, child := range [2]*Cell{cell.Lower, cell.Upper} {
+		if child != nil {
+			child.refit(split)
 		}
 	}
 }
 
+// Moves the particles below cell that left their leaf to their new leaf
+func (cell *Cell) moveCrossed(split SplitStrategy) {
+	leaves := cell.appendLeaves(nil)
+
+	incoming := make(map[*Cell][]Particle)
+	for _, leaf := range leaves {
+		for i := range leaf.Particles {
+			if !leaf.contains(&leaf.Particles[i].Pos) {
+				target := cell.leafAt(leaf.Particles[i].Pos)
+				incoming[target] = append(incoming[target], leaf.Particles[i])
+			}
+		}
+	}
+
+	// the leaves in tree order, the new ones included, each one with the
+	// particles that stayed followed by the ones that came in
+	moved := make([]Particle, 0, len(cell.Particles))
+	for _, leaf := range cell.appendLeaves(leaves[:0]) {
+		start := len(moved)
+		for i := range leaf.Particles {
+			if leaf.contains(&leaf.Particles[i].Pos) {
+				moved = append(moved, leaf.Particles[i])
+			}
+		}
+		if len(moved)-start != len(leaf.Particles) || len(incoming[leaf]) > 0 {
+			leaf.moved = true
+		}
+		moved = append(moved, incoming[leaf]...)
+		leaf.Particles = moved[start:len(moved)]
+	}
+
+	copy(cell.Particles, moved)
+	cell.settle(cell.Particles, cell.Offset, cell.Offset, split)
+}
+
+func (cell *Cell) appendLeaves(leaves []*Cell) []*Cell {
+	if cell.Upper == nil && cell.Lower == nil {
+		return append(leaves, cell)
+	}
+	if cell.Lower != nil {
+		leaves = cell.Lower.appendLeaves(leaves)
+	}
+	if cell.Upper != nil {
+		leaves = cell.Upper.appendLeaves(leaves)
+	}
+	return leaves
+}
+
+// The leaf below cell for pos along the splits, missing children are added
+// as empty leaves
+func (cell *Cell) leafAt(pos Vec2) *Cell {
+	for cell.Upper != nil || cell.Lower != nil {
+		x := pos.X
+		if cell.SplitAxis == Vertical {
+			x = pos.Y
+		}
+		lower := x <= cell.SplitAt
+
+		child := &cell.Upper
+		if lower {
+			child = &cell.Lower
+		}
+		if *child == nil {
+			*child = &Cell{
+				LowerLeft:   cell.LowerLeft,
+				UpperRight:  cell.UpperRight,
+				Orientation: cell.SplitAxis.other(),
+			}
+			if cell.SplitAxis == Vertical && lower {
+				(*child).UpperRight.Y = cell.SplitAt
+			} else if cell.SplitAxis == Vertical {
+				(*child).LowerLeft.Y = cell.SplitAt
+			} else if lower {
+				(*child).UpperRight.X = cell.SplitAt
+			} else {
+				(*child).LowerLeft.X = cell.SplitAt
+			}
+		}
+		cell = *child
+	}
+	return cell
+}
+
+// Gives the cells their range of particles again after moveCrossed(), the
+// particles of the leaves are in order starting at offset. The leaves with
+// too many particles are split, the empty ones removed and the cells with
+// few particles merged into a leaf. Returns the number of particles of cell.
+func (cell *Cell) settle(particles []Particle, base, offset int, split SplitStrategy) int {
+	if cell.Upper == nil && cell.Lower == nil {
+		n := len(cell.Particles)
+		cell.Offset = offset
+		cell.Particles = particles[offset-base : offset-base+n]
+		if n > MAX_PARTICLES_PER_CELL {
+			cell.TreebuildWith(split, cell.Orientation)
+			cell.BoundingSpheres()
+		}
+		return n
+	}
+
+	n := 0
+	for _, child := range [2]**Cell{&cell.Lower, &cell.Upper} {
+		if *child == nil {
+			continue
+		}
+		m := (*child).settle(particles, base, offset+n, split)
+		if m == 0 {
+			*child = nil
+			cell.moved = true
+			continue
+		}
+		cell.moved = cell.moved || (*child).moved
+		n += m
+	}
+
+	cell.Offset = offset
+	cell.Particles = particles[offset-base : offset-base+n]
+	if n <= MAX_PARTICLES_PER_CELL {
+		cell.Lower = nil
+		cell.Upper = nil
+		cell.built = n
+		cell.moved = true
+	}
+	return n
+}
+
+// Recalculates the bounding spheres of the cells marked by Refit()
+func (cell *Cell) updateSpheres() {
+	if !cell.moved {
+		return
+	}
+	cell.moved = false
+
+	if cell.Upper == nil && cell.Lower == nil {
+		cell.BoundingSpheres()
+		return
+	}
+	for _, child := range [2]*Cell{cell.Lower, cell.Upper} {
+		if child != nil {
+			child.updateSpheres()
+		}
+	}
+	cell.mergeBoundingSpheres()
+}
+
+// Largest factor by which the particles of a cell grew or shrank since the
+// cell was built, only cells with at least REFIT_MIN_PARTICLES are looked at.
+// A refitted tree keeps the splits of the particles it was built for.
+func (cell *Cell) Imbalance() float64 {
+	n, built := float64(len(cell.Particles)), float64(cell.built)
+	if math.Max(n, built) < REFIT_MIN_PARTICLES {
+		return 1
+	}
+
+	imbalance := math.Max(n, built) / math.Max(math.Min(n, built), 1)
+	for _, child := range [2]*Cell{cell.Lower, cell.Upper} {
+		if child != nil {
+			imbalance = math.Max(imbalance, child.Imbalance())
+		}
+	}
+	return imbalance
+}
+
+func (cell *Cell) contains(pos *Vec2) bool {
+	return pos.X >= cell.LowerLeft.X && pos.X <= cell.UpperRight.X &&
+		pos.Y >= cell.LowerLeft.Y && pos.Y <= cell.UpperRight.Y
+}
+
+func coincident(particles []Particle) bool {
+	for i := range particles {
+		if particles[i].Pos != particles[0].Pos {
+			return false
+		}
+	}
+	return true
+}
+
 // Adapted idea from: (might be worse)
 // 1990, Jack Ritter proposed a simple algorithm to find a non-minimal bounding sphere.
 // https://en.wikipedia.org/wiki/Bounding_sphere, 2024
@@ -277,6 +619,12 @@ func (root *Cell) BoundingSpheres() {
 		root.Lower.BoundingSpheres()
 	}
 
+	root.mergeBoundingSpheres()
+}
+
+// bounding sphere of a cell from the ones of its children
+func (root *Cell) mergeBoundingSpheres() {
+
 	// at max one is not nil!
 	// if unbalanced, just copy the values from the one
 	if root.Upper == nil {
@@ -303,6 +651,19 @@ func (root *Cell) BoundingSpheres() {
 
 		rA := root.Lower.BRadius
 		rB := root.Upper.BRadius
+
+		// one circle already encloses the other (also catches ABNorm == 0)
+		if rA >= ABNorm+rB {
+			root.BCenter = root.Lower.BCenter
+			root.BRadius = rA
+			return
+		}
+		if rB >= ABNorm+rA {
+			root.BCenter = root.Upper.BCenter
+			root.BRadius = rB
+			return
+		}
+
 		rC := (rA + rB + ABNorm) * 0.5
 		mid := AB.Mul((rB - rC) / ABNorm)
 
@@ -324,6 +685,49 @@ func (root *Cell) Dumptree(level int) {
 	}
 }
 
+// Shape of a tree, to compare the split strategies
+type TreeStats struct {
+	Depth  int
+	Leaves int
+
+	// particles per leaf
+	MinOccupancy  int
+	MaxOccupancy  int
+	MeanOccupancy float64
+}
+
+func (root *Cell) Stats() TreeStats {
+	stats := TreeStats{
+		Depth:        root.Depth(),
+		MinOccupancy: len(root.Particles),
+	}
+	root.leafStats(&stats)
+	if stats.Leaves > 0 {
+		stats.MeanOccupancy = float64(len(root.Particles)) / float64(stats.Leaves)
+	}
+	return stats
+}
+
+func (root *Cell) leafStats(stats *TreeStats) {
+	if root.Upper == nil && root.Lower == nil {
+		stats.Leaves += 1
+		stats.MinOccupancy = Min(stats.MinOccupancy, len(root.Particles))
+		stats.MaxOccupancy = Max(stats.MaxOccupancy, len(root.Particles))
+		return
+	}
+	if root.Upper != nil {
+		root.Upper.leafStats(stats)
+	}
+	if root.Lower != nil {
+		root.Lower.leafStats(stats)
+	}
+}
+
+func (stats TreeStats) String() string {
+	return fmt.Sprintf("depth %v, %v leaves, particles per leaf min %v max %v mean %.3v",
+		stats.Depth, stats.Leaves, stats.MinOccupancy, stats.MaxOccupancy, stats.MeanOccupancy)
+}
+
 func (root *Cell) Depth() int {
 	a, b := 0, 0
 	if root.Upper != nil {
@@ -0,0 +1,136 @@
+/* Equations of state
+
+They give the pressure P and the speed of sound c from the density rho and the
+specific internal energy e:
+
+  - IdealGas:   P = (Gamma - 1) rho e,                c = sqrt(Gamma (Gamma - 1) e)
+  - Isothermal: P = C^2 rho,                          c = C
+  - Polytropic: P = K rho^Gamma,                      c = sqrt(Gamma P / rho)
+  - Tait:       P = B ((rho/rho0)^Gamma - 1) + P0,    c = c0 (rho/rho0)^((Gamma-1)/2)
+
+with B = rho0 c0^2 / Gamma for Tait (Cole 1948, Monaghan 1994). It is the usual
+choice for weakly compressible water: c0 about 10 times the highest velocity
+keeps the density within ~1% of rho0. Gamma is 7 for water.
+
+Only the ideal gas uses e, for the others the energy equation still runs but
+does not feed back.
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+type EOS interface {
+	Pressure(rho, e float64) float64
+	SoundSpeed(rho, e float64) float64
+}
+
+// Equations of state by their name in the config file, with default parameters
+var EOSs = map[string]EOS{
+	"IdealGas":   IdealGas{Gamma: 1.66666},
+	"Isothermal": Isothermal{C: 1},
+	"Polytropic": Polytropic{K: 1, Gamma: 2},
+	"Tait":       Tait{Rho0: 1, C0: 10, Gamma: 7},
+}
+
+// The default
+type IdealGas struct {
+	Gamma float64 // heat capacity ratio = 1 + 2/f
+}
+
+func (eos IdealGas) Pressure(rho, e float64) float64 {
+	return (eos.Gamma - 1) * rho * e
+}
+
+func (eos IdealGas) SoundSpeed(rho, e float64) float64 {
+	return math.Sqrt(eos.Gamma * (eos.Gamma - 1) * e)
+}
+
+type Isothermal struct {
+	C float64 // Speed of sound
+}
+
+func (eos Isothermal) Pressure(rho, e float64) float64 {
+	return eos.C * eos.C * rho
+}
+
+func (eos Isothermal) SoundSpeed(rho, e float64) float64 {
+	return eos.C
+}
+
+type Polytropic struct {
+	K     float64
+	Gamma float64
+}
+
+func (eos Polytropic) Pressure(rho, e float64) float64 {
+	return eos.K * math.Pow(rho, eos.Gamma)
+}
+
+func (eos Polytropic) SoundSpeed(rho, e float64) float64 {
+	if !(rho > 0) {
+		return 0
+	}
+	return math.Sqrt(eos.Gamma * eos.Pressure(rho, e) / rho)
+}
+
+// Tait/Cole equation for weakly compressible liquids
+type Tait struct {
+	Rho0  float64 // Rest density
+	C0    float64 // Speed of sound at Rho0
+	Gamma float64 // 7 for water
+	P0    float64 // Background pressure, against the tensile instability
+}
+
+func (eos Tait) Pressure(rho, e float64) float64 {
+	b := eos.Rho0 * eos.C0 * eos.C0 / eos.Gamma
+	return b*(math.Pow(rho/eos.Rho0, eos.Gamma)-1) + eos.P0
+}
+
+func (eos Tait) SoundSpeed(rho, e float64) float64 {
+	return eos.C0 * math.Pow(rho/eos.Rho0, 0.5*(eos.Gamma-1))
+}
+
+// Sets the parameter name of eos from the [EOS] section of the config, false
+// if eos doesn't have it
+func setEOSParam(eos EOS, name string, value float64) (EOS, bool) {
+	switch eos := eos.(type) {
+	case IdealGas:
+		if name == "Gamma" {
+			eos.Gamma = value
+			return eos, true
+		}
+	case Isothermal:
+		if name == "SoundSpeed" {
+			eos.C = value
+			return eos, true
+		}
+	case Polytropic:
+		switch name {
+		case "K":
+			eos.K = value
+			return eos, true
+		case "Gamma":
+			eos.Gamma = value
+			return eos, true
+		}
+	case Tait:
+		switch name {
+		case "RestDensity":
+			eos.Rho0 = value
+			return eos, true
+		case "SoundSpeed":
+			eos.C0 = value
+			return eos, true
+		case "Gamma":
+			eos.Gamma = value
+			return eos, true
+		case "BackgroundPressure":
+			eos.P0 = value
+			return eos, true
+		}
+	}
+	return eos, false
+}
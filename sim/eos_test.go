@@ -0,0 +1,74 @@
+package sim
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// c^2 = dP/drho at constant entropy, for the ideal gas de = P/rho^2 drho
+func TestEOSSoundSpeed(t *testing.T) {
+	eoss := map[string]EOS{
+		"IdealGas":   IdealGas{Gamma: 1.4},
+		"Isothermal": Isothermal{C: 2},
+		"Polytropic": Polytropic{K: 0.5, Gamma: 2},
+		"Tait":       Tait{Rho0: 1000, C0: 30, Gamma: 7, P0: 100},
+	}
+
+	const drho = 1e-6
+	for name, eos := range eoss {
+		for _, rho := range []float64{950, 1000, 1050} {
+			e := 3.0
+			p := eos.Pressure(rho, e)
+			de := 0.0
+			if _, ok := eos.(IdealGas); ok {
+				de = p / (rho * rho) * drho * rho
+			}
+			dP := (eos.Pressure(rho*(1+drho), e+de) - p) / (rho * drho)
+			c := eos.SoundSpeed(rho, e)
+			if math.Abs(c*c-dP) > 1e-4*c*c {
+				t.Fatalf("%v at rho %v: c^2 = %v but dP/drho = %v", name, rho, c*c, dP)
+			}
+		}
+	}
+}
+
+func TestTaitRestDensity(t *testing.T) {
+	eos := Tait{Rho0: 1000, C0: 30, Gamma: 7, P0: 100}
+	if p := eos.Pressure(1000, 0); p != 100 {
+		t.Fatalf("pressure at rest density should be the background pressure, got %v", p)
+	}
+	if c := eos.SoundSpeed(1000, 0); c != 30 {
+		t.Fatalf("speed of sound at rest density should be C0, got %v", c)
+	}
+	if eos.Pressure(990, 0) >= 100 {
+		t.Fatalf("expanded water should be below the background pressure")
+	}
+}
+
+func TestEOSConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "eos.conf")
+	source := "[[Simulation]]\n[EOS]\nType Tait\nRestDensity 1000\nSoundSpeed 20\nBackgroundPressure 5\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := MakeConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("could not parse eos config: %v", err)
+	}
+	want := Tait{Rho0: 1000, C0: 20, Gamma: 7, P0: 5}
+	if conf.EOS != want {
+		t.Fatalf("eos config not applied: %+v", conf.EOS)
+	}
+
+	// K belongs to Polytropic only
+	source = "[[Simulation]]\n[EOS]\nType Isothermal\nK 1\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeConfigFromFile(path); err == nil {
+		t.Fatalf("K should not be accepted for the isothermal EOS")
+	}
+}
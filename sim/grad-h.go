@@ -0,0 +1,302 @@
+/* Variable smoothing length with grad-h terms
+
+(Springel & Hernquist 2002, Price 2012). Every particle solves for its kernel
+support H such that NNSize particles are inside of it on average:
+
+	rho(H) * H^2 = NNSize * m / pi,   rho(H) = sum_b m_b W(r_ab, H)
+
+with a Newton iteration over the neighbour candidates, GRADH_CANDIDATES times
+NNSize of them are searched. H can't get bigger than the farthest candidate.
+Because H depends on rho, the derivatives of the kernels pick up
+
+	Omega = 1 - dH/drho sum_b m_b dW_ab(H)/dH,   dH/drho = -H / (2 rho)
+
+and the equations of motion become
+
+	dv_a/dt = -sum_b m_b [ P_a/(Omega_a rho_a^2) grad W_ab(H_a) + P_b/(Omega_b rho_b^2) grad W_ab(H_b) + Pi_ab grad W_ab ]
+	du_a/dt =  P_a/(Omega_a rho_a^2) sum_b m_b v_ab . grad W_ab(H_a) + 1/2 sum_b m_b Pi_ab v_ab . grad W_ab
+
+grad W_ab is the mean of both kernels. This conserves momentum and energy
+exactly, if all pairs within max(H_a, H_b) are seen from both sides. That is
+why the forces use PairSearch() and not the nearest neighbour lists.
+*/
+
+package sim
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	GRADH_CANDIDATES = 2.0  // Neighbour candidates searched per NNSize
+	GRADH_TOLERANCE  = 1e-6 // Relative accuracy of H
+	GRADH_MAX_ITER   = 50
+)
+
+// Number of neighbours searched by FindNearestNeighbours()
+func (sim *Simulation) NNSearchSize() int {
+	if sim.Config.GradH {
+		return int(math.Ceil(GRADH_CANDIDATES * float64(sim.Config.NNSize)))
+	}
+	return sim.Config.NNSize
+}
+
+// W(r, h) and dW/dr(r, h), 0 outside of the support
+func kernelW(kernel Kernel, r, h float64) float64 {
+	q := r / h
+	if q >= 1 {
+		return 0
+	}
+	return kernel.FPrefactor * kernel.F(q) / (h * h)
+}
+
+func kernelDW(kernel Kernel, r, h float64) float64 {
+	q := r / h
+	if q >= 1 {
+		return 0
+	}
+	return kernel.DFPrefactor * kernel.DF(q) / (h * h * h)
+}
+
+// Solves for H of particle i with its neighbour candidates in sim.Neighbours
+// and sets H, Rho and Omega. See top of file.
+func SmoothingLength2D(i int, sim *Simulation, kernel Kernel) {
+	p := &sim.Root.Particles[i]
+	nn := sim.Neighbours.Of(i)
+	target := float64(sim.Config.NNSize) * p.Mass / math.Pi
+
+	// rho(h) and its derivative drho/dh, the particle itself is not in the list
+	density := func(h float64) (rho, drho float64) {
+		rho = p.Mass * kernelW(kernel, 0, h)
+		drho = -2 * rho / h
+		for j := range nn.Dists {
+			if nn.Index[j] < 0 {
+				continue
+			}
+			m := sim.Root.Particles[nn.Index[j]].Mass
+			w := kernelW(kernel, nn.Dists[j], h)
+			dw := kernelDW(kernel, nn.Dists[j], h)
+			rho += m * w
+			drho -= m * (2*w + nn.Dists[j]*dw) / h
+		}
+		return rho, drho
+	}
+
+	// farthest candidate, with less particles than that in the whole
+	// simulation some are missing
+	hi := 0.0
+	for j := range nn.Dists {
+		if nn.Index[j] >= 0 {
+			hi = math.Max(hi, nn.Dists[j])
+		}
+	}
+	if hi == 0 {
+		p.Rho = p.Mass * kernelW(kernel, 0, p.H)
+		p.Omega = 1
+		return
+	}
+
+	// f(h) = rho(h) - target / h^2 is negative for small h and grows with
+	// h, Newton is kept inside the bracket [lo, hi] with bisection
+	lo := 0.0
+	h := nn.Dists[len(nn.Dists)-sim.Config.NNSize]
+	if !(h > 0) || h > hi {
+		h = 0.5 * hi
+	}
+
+	rho, drho := density(hi)
+	if rho*hi*hi < target {
+		// not enough candidates, H stays at the farthest one
+		h = hi
+	} else {
+		for range GRADH_MAX_ITER {
+			rho, drho = density(h)
+			f := rho - target/(h*h)
+			if f < 0 {
+				lo = h
+			} else {
+				hi = h
+			}
+
+			df := drho + 2*target/(h*h*h)
+			next := h - f/df
+			if !(next > lo && next < hi) {
+				next = 0.5 * (lo + hi)
+			}
+
+			done := math.Abs(next-h) < GRADH_TOLERANCE*h
+			h = next
+			if done {
+				break
+			}
+		}
+		rho, drho = density(h)
+	}
+
+	p.H = h
+	p.Rho = rho
+	p.Omega = 1 + h/(2*rho)*drho
+	if !(p.Omega > 0) {
+		p.Omega = 1
+	}
+}
+
+// Sets HMax of all cells
+func (cell *Cell) UpdateHMax() {
+	cell.HMax = 0
+	if cell.Upper == nil && cell.Lower == nil {
+		for i := range cell.Particles {
+			cell.HMax = math.Max(cell.HMax, cell.Particles[i].H)
+		}
+		return
+	}
+	for _, child := range [2]*Cell{cell.Lower, cell.Upper} {
+		if child != nil {
+			child.UpdateHMax()
+			cell.HMax = math.Max(cell.HMax, child.HMax)
+		}
+	}
+}
+
+// Finds all particles b with |pos - b.Pos| < max(h, b.H), the ones the
+// particle at pos with support h interacts with. The particle itself is
+// found as well. UpdateHMax() has to be called before. See BallSearch() for
+// the lists.
+func (root *Cell) PairSearch(pos Vec2, h float64, found NNList) NNList {
+	return root.pairSearchRec(pos, h, Vec2{0, 0}, found)
+}
+
+// Periodic version, see BallSearchPeriodic()
+func (root *Cell) PairSearchPeriodic(pos Vec2, h float64, HorPeriodic, VertPeriodic [2]float64, found NNList) NNList {
+	r := math.Max(h, root.HMax)
+	offsets, n := periodicOffsets(HorPeriodic, VertPeriodic)
+	for _, offset := range offsets[:n] {
+		image := pos.Add(&offset)
+		if root.DistSquared(&image) < r*r {
+			found = root.pairSearchRec(pos, h, offset, found)
+		}
+	}
+	return found
+}
+
+func (root *Cell) pairSearchRec(pos Vec2, h float64, offset Vec2, found NNList) NNList {
+
+	query := pos.Add(&offset)
+
+	r := math.Max(h, root.HMax)
+	distCenter := Dist(root.BCenter, query) - root.BRadius
+	if distCenter > 0 && distCenter >= r {
+		return found
+	}
+
+	if root.Upper == nil && root.Lower == nil {
+		for i := range root.Particles {
+			b := &root.Particles[i]
+			d2 := DistSq(query, b.Pos)
+			rb := math.Max(h, b.H)
+			if d2 < rb*rb {
+				found.Index = append(found.Index, int32(root.Offset+i))
+				found.Dists = append(found.Dists, math.Sqrt(d2))
+				found.Pos = append(found.Pos, b.Pos.Sub(&offset))
+			}
+		}
+		return found
+	}
+
+	if root.Lower != nil {
+		found = root.Lower.pairSearchRec(pos, h, offset, found)
+	}
+	if root.Upper != nil {
+		found = root.Upper.pairSearchRec(pos, h, offset, found)
+	}
+	return found
+}
+
+// buffers for the pair search of the workers
+var pairLists = sync.Pool{
+	New: func() any {
+		return &NNList{}
+	},
+}
+
+// Like AccelerationAndEDot2D() but with the grad-h terms and the symmetric
+// neighbours, see top of file. Rho, H and Omega of all particles have to be
+// set by SmoothingLength2D() and HMax by UpdateHMax().
+func AccelerationAndEDotGradH2D(i int, sim *Simulation, kernel Kernel) {
+	p := &sim.Root.Particles[i]
+	visc := &sim.Config.Viscosity
+	st := &sim.Config.SurfaceTension
+	physical := sim.Config.PhysicalViscosity()
+	muA := sim.Config.MuOf(sim.State.Species[i])
+	viscA := &sim.State.Viscous[i]
+
+	list := pairLists.Get().(*NNList)
+	*list = sim.Root.PairSearchPeriodic(p.Pos, p.H, sim.Config.HorPeriodicity, sim.Config.VertPeriodicity, list.Reset())
+	nns := *list
+
+	// PA / (OmegaA rhoA^2)
+	contributionA := p.P / (p.Omega * p.Rho * p.Rho)
+
+	acc := Vec2{}
+	edot := 0.0
+	muMax := 0.0
+
+	for j := range nns.Index {
+		r := nns.Dists[j]
+		if nns.Index[j] == int32(i) && r == 0 {
+			// the particle itself, its periodic images are neighbours
+			continue
+		}
+		b := int(nns.Index[j])
+		nn := &sim.Root.Particles[b]
+
+		// grad W is 0 between particles on top of each other
+		invR := 0.0
+		if r > 0 {
+			invR = 1 / r
+		}
+
+		// PB / (OmegaB rhoB^2)
+		contributionB := nn.P / (nn.Omega * nn.Rho * nn.Rho)
+
+		dWA := kernelDW(kernel, r, p.H)
+		dWB := kernelDW(kernel, r, nn.H)
+		dWMean := 0.5 * (dWA + dWB)
+
+		vAB := nn.VPred.Sub(&p.VPred)
+		rAB := nns.Pos[j].Sub(&p.Pos)
+		dot := vAB.Dot(&rAB)
+
+		piAB, muAB := visc.Pi(p, nn, viscA, &sim.State.Viscous[b], rAB, vAB)
+		muMax = math.Max(muMax, -muAB)
+
+		// grad_a W = -dW/dr rAB / r
+		s := nn.Mass * (contributionA*dWA + contributionB*dWB + piAB*dWMean) * invR
+		acc.X += s * rAB.X
+		acc.Y += s * rAB.Y
+
+		// v_ab . grad_a W = dot / r * dW/dr
+		edot += nn.Mass * (contributionA*dWA + 0.5*piAB*dWMean) * dot * invR
+
+		if physical {
+			nuAB := nn.Mass * Morris(p, nn, muA, sim.Config.MuOf(sim.State.Species[b]), r, dWMean, 0.5*(p.H+nn.H))
+			vVisc := sim.viscousVelocity(b)
+			vVisc = vVisc.Sub(&p.VPred)
+			acc.X += nuAB * vVisc.X
+			acc.Y += nuAB * vVisc.Y
+			edot += 0.5 * nuAB * vVisc.Dot(&vVisc)
+		}
+
+		if st.Tension > 0 {
+			dv := st.Acceleration(p, nn, sim.State.Normal[i], sim.State.Normal[b], rAB, r)
+			acc = acc.Add(&dv)
+		}
+	}
+
+	pairLists.Put(list)
+
+	p.VDot = acc.Add(&sim.Config.Acceleration)
+	p.EDot = edot
+	p.VSig = visc.SignalSpeed(p, viscA, muMax)
+}
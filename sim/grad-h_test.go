@@ -0,0 +1,138 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func gradHTestSimulation(periodic bool) *Simulation {
+	conf := MakeConfig()
+	conf.Start = []ParticleSource{UniformRectSpawner{
+		UpperLeft:  Vec2{0.1, 0.1},
+		LowerRight: Vec2{0.6, 0.9},
+		NParticles: 800,
+	}}
+	conf.GradH = true
+	conf.Kernel = WendlandC2Kernel2D
+	if periodic {
+		conf.HorPeriodicity = [2]float64{0, 1}
+		conf.VertPeriodicity = [2]float64{0, 1}
+	}
+
+	sim := MakeSimulationFromConf(conf)
+	r := rand.New(rand.NewSource(4))
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		p.Vel = Vec2{r.Float64() - 0.5, r.Float64() - 0.5}
+		p.VPred = p.Vel
+		p.E = 0.5 + r.Float64()
+		p.EPred = p.E
+		// a few heavier ones, so H varies
+		if i%7 == 0 {
+			p.Mass *= 3
+		}
+	}
+	sim.CalculateForces()
+	return &sim
+}
+
+func TestSmoothingLength(t *testing.T) {
+	sim := gradHTestSimulation(false)
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		nn := sim.Neighbours.Of(i)
+		if p.H > nn.Dists[0] {
+			t.Fatalf("H %v bigger than the farthest candidate %v", p.H, nn.Dists[0])
+		}
+		if p.H == nn.Dists[0] {
+			continue // not enough candidates, at the border
+		}
+		want := float64(sim.Config.NNSize) * p.Mass / math.Pi
+		if math.Abs(p.Rho*p.H*p.H-want) > 1e-4*want {
+			t.Fatalf("particle %v: rho h^2 = %v, expected %v", i, p.Rho*p.H*p.H, want)
+		}
+		if !(p.Omega > 0) {
+			t.Fatalf("particle %v: Omega %v", i, p.Omega)
+		}
+	}
+}
+
+func TestPairSearch(t *testing.T) {
+	for _, periodic := range []bool{false, true} {
+		sim := gradHTestSimulation(periodic)
+		conf := &sim.Config
+		particles := sim.Root.Particles
+
+		for i := 0; i < len(particles); i += 37 {
+			p := &particles[i]
+			found := sim.Root.PairSearchPeriodic(p.Pos, p.H, conf.HorPeriodicity, conf.VertPeriodicity, NNList{})
+
+			want := []int32{}
+			for j := range particles {
+				d := p.Pos.Sub(&particles[j].Pos)
+				if periodic {
+					d.X -= math.Round(d.X)
+					d.Y -= math.Round(d.Y)
+				}
+				if d.Norm() < math.Max(p.H, particles[j].H) {
+					want = append(want, int32(j))
+				}
+			}
+
+			got := slices.Clone(found.Index)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Fatalf("periodic %v particle %v: found %v pairs, expected %v", periodic, i, len(got), len(want))
+			}
+		}
+	}
+}
+
+// with the grad-h terms the forces conserve momentum and energy exactly
+func TestGradHConservation(t *testing.T) {
+	for _, periodic := range []bool{false, true} {
+		sim := gradHTestSimulation(periodic)
+
+		momentum := Vec2{}
+		energy := 0.0
+		scale := 0.0
+		for i := range sim.Root.Particles {
+			p := &sim.Root.Particles[i]
+			a := p.VDot.Sub(&sim.Config.Acceleration)
+			momentum = momentum.Add(&Vec2{p.Mass * a.X, p.Mass * a.Y})
+			energy += p.Mass * (p.Vel.Dot(&a) + p.EDot)
+			scale += p.Mass * math.Abs(p.EDot)
+		}
+
+		if momentum.Norm() > 1e-10*scale || math.Abs(energy) > 1e-10*scale {
+			t.Fatalf("periodic %v: dP/dt = %v and dE/dt = %v, scale %v", periodic, momentum, energy, scale)
+		}
+	}
+}
+
+// distinct particles on top of each other are neighbours, not the particle itself
+func TestGradHCoincidentParticles(t *testing.T) {
+	sim := gradHTestSimulation(false)
+	for i := 1; i < 40; i += 2 {
+		sim.Root.Particles[i].Pos = sim.Root.Particles[i-1].Pos
+	}
+	sim.CalculateForces()
+
+	momentum := Vec2{}
+	scale := 0.0
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		SurfaceNormal2D(i, sim, sim.Config.Kernel)
+		if math.IsNaN(p.VDot.X) || math.IsNaN(p.VDot.Y) || math.IsNaN(p.EDot) || math.IsNaN(sim.State.Normal[i].X) {
+			t.Fatalf("particle %v at %v has VDot %v, EDot %v and normal %v", i, p.Pos, p.VDot, p.EDot, sim.State.Normal[i])
+		}
+		a := p.VDot.Sub(&sim.Config.Acceleration)
+		momentum = momentum.Add(&Vec2{p.Mass * a.X, p.Mass * a.Y})
+		scale += p.Mass * a.Norm()
+	}
+	if momentum.Norm() > 1e-10*scale {
+		t.Fatalf("dP/dt = %v, scale %v", momentum, scale)
+	}
+}
@@ -0,0 +1,188 @@
+/* Self gravity with the Barnes-Hut algorithm
+
+The cells of the tree carry their mass, centre of mass and (optionally) the
+quadrupole moment. A cell is not opened if it is seen under a small enough
+angle from the particle:
+
+	2 * BRadius / distance < Theta
+
+then its multipole expansion is used instead of all of its particles. Theta = 0
+opens every cell, which is the same as the direct sum over all particles.
+
+The gravity is the one of point masses in 3d (1/r potential) for particles
+in the plane, with Plummer softening. Periodic boundaries are ignored.
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+type Gravity struct {
+	G          float64 // Gravitational constant, 0 turns gravity off
+	Theta      float64 // Opening angle
+	Softening  float64 // Plummer softening length
+	Quadrupole bool    // Use the quadrupole moments of the cells, otherwise monopoles only
+}
+
+// sensible defaults, gravity is off
+func MakeGravity() Gravity {
+	return Gravity{
+		Theta:     0.5,
+		Softening: 0.01,
+	}
+}
+
+// Calculates Mass, CoM and if quadrupole is set Quad for all cells.
+// Particles need their Mass set.
+func (cell *Cell) Moments(quadrupole bool) {
+	cell.Quad = [3]float64{}
+
+	if cell.Upper == nil && cell.Lower == nil {
+		cell.Mass = 0
+		cell.CoM = Vec2{}
+		for i := range cell.Particles {
+			p := &cell.Particles[i]
+			cell.Mass += p.Mass
+			cell.CoM.X += p.Mass * p.Pos.X
+			cell.CoM.Y += p.Mass * p.Pos.Y
+		}
+		if cell.Mass > 0 {
+			cell.CoM = cell.CoM.Mul(1 / cell.Mass)
+		}
+
+		if quadrupole {
+			for i := range cell.Particles {
+				p := &cell.Particles[i]
+				cell.addQuad(p.Mass, p.Pos.Sub(&cell.CoM))
+			}
+		}
+		return
+	}
+
+	children := [2]*Cell{cell.Lower, cell.Upper}
+
+	cell.Mass = 0
+	cell.CoM = Vec2{}
+	for _, child := range children {
+		if child == nil {
+			continue
+		}
+		child.Moments(quadrupole)
+		cell.Mass += child.Mass
+		cell.CoM.X += child.Mass * child.CoM.X
+		cell.CoM.Y += child.Mass * child.CoM.Y
+	}
+	if cell.Mass > 0 {
+		cell.CoM = cell.CoM.Mul(1 / cell.Mass)
+	}
+
+	// shift the moments of the children to the new centre
+	if quadrupole {
+		for _, child := range children {
+			if child == nil {
+				continue
+			}
+			cell.Quad[0] += child.Quad[0]
+			cell.Quad[1] += child.Quad[1]
+			cell.Quad[2] += child.Quad[2]
+			cell.addQuad(child.Mass, child.CoM.Sub(&cell.CoM))
+		}
+	}
+}
+
+// traceless quadrupole of mass m at d from the centre of mass:
+// Q_ij = m (3 d_i d_j - d^2 delta_ij), stored as xx, xy, yy
+func (cell *Cell) addQuad(m float64, d Vec2) {
+	d2 := d.X*d.X + d.Y*d.Y
+	cell.Quad[0] += m * (3*d.X*d.X - d2)
+	cell.Quad[1] += m * 3 * d.X * d.Y
+	cell.Quad[2] += m * (3*d.Y*d.Y - d2)
+}
+
+// Gravitational acceleration at pos from all particles in the cell, except
+// the particle self (can be nil). Moments() has to be called before.
+func (cell *Cell) GravityAt(pos Vec2, self *Particle, g *Gravity) Vec2 {
+	acc := Vec2{}
+	cell.gravityRec(pos, self, g, &acc)
+	return acc.Mul(g.G)
+}
+
+func (cell *Cell) gravityRec(pos Vec2, self *Particle, g *Gravity, acc *Vec2) {
+	if cell.Mass == 0 {
+		return
+	}
+
+	eps2 := g.Softening * g.Softening
+
+	if cell.Upper == nil && cell.Lower == nil {
+		for i := range cell.Particles {
+			p := &cell.Particles[i]
+			if p == self {
+				continue
+			}
+			r := p.Pos.Sub(&pos)
+			r2 := r.X*r.X + r.Y*r.Y + eps2
+			f := p.Mass / (r2 * math.Sqrt(r2))
+			acc.X += f * r.X
+			acc.Y += f * r.Y
+		}
+		return
+	}
+
+	// r points from the centre of mass to pos
+	r := pos.Sub(&cell.CoM)
+	dist2 := r.X*r.X + r.Y*r.Y
+	size := 2 * cell.BRadius
+
+	if size*size < g.Theta*g.Theta*dist2 {
+		r2 := dist2 + eps2
+		inv := 1 / math.Sqrt(r2)
+		inv3 := inv / r2
+
+		acc.X -= cell.Mass * r.X * inv3
+		acc.Y -= cell.Mass * r.Y * inv3
+
+		if g.Quadrupole {
+			// a = Q r / r^5 - 5/2 (r Q r) r / r^7
+			inv5 := inv3 / r2
+			qr := Vec2{cell.Quad[0]*r.X + cell.Quad[1]*r.Y, cell.Quad[1]*r.X + cell.Quad[2]*r.Y}
+			rqr := r.Dot(&qr)
+			acc.X += qr.X*inv5 - 2.5*rqr*r.X*inv5/r2
+			acc.Y += qr.Y*inv5 - 2.5*rqr*r.Y*inv5/r2
+		}
+		return
+	}
+
+	if cell.Lower != nil {
+		cell.Lower.gravityRec(pos, self, g, acc)
+	}
+	if cell.Upper != nil {
+		cell.Upper.gravityRec(pos, self, g, acc)
+	}
+}
+
+// Adds the self gravity to the accelerations VDot of all particles
+func (sim *Simulation) AddGravity() {
+	sim.addGravity(nil)
+}
+
+// only to the active particles, nil means all
+func (sim *Simulation) addGravity(active func(p *Particle) bool) {
+	g := &sim.Config.Gravity
+	if g.G == 0 {
+		return
+	}
+
+	sim.Root.Moments(g.Quadrupole)
+
+	sim.parallelFor(len(sim.Root.Particles), func(i int) {
+		p := &sim.Root.Particles[i]
+		if sim.State.Wall[i] || active != nil && !active(p) {
+			return
+		}
+		acc := sim.Root.GravityAt(p.Pos, p, g)
+		p.VDot = p.VDot.Add(&acc)
+	})
+}
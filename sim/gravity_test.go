@@ -0,0 +1,90 @@
+package sim
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// direct sum over all other particles
+func directGravity(particles []Particle, self int, g *Gravity) Vec2 {
+	acc := Vec2{}
+	eps2 := g.Softening * g.Softening
+	for j := range particles {
+		if j == self {
+			continue
+		}
+		r := particles[j].Pos.Sub(&particles[self].Pos)
+		r2 := r.X*r.X + r.Y*r.Y + eps2
+		f := g.G * particles[j].Mass / (r2 * math.Sqrt(r2))
+		acc.X += f * r.X
+		acc.Y += f * r.Y
+	}
+	return acc
+}
+
+// mean relative error of the tree against the direct sum
+func gravityError(root *Cell, g *Gravity) float64 {
+	root.Moments(g.Quadrupole)
+
+	sum := 0.0
+	for i := range root.Particles {
+		exact := directGravity(root.Particles, i, g)
+		acc := root.GravityAt(root.Particles[i].Pos, &root.Particles[i], g)
+		diff := acc.Sub(&exact)
+		sum += diff.Norm() / exact.Norm()
+	}
+	return sum / float64(len(root.Particles))
+}
+
+func TestGravity(t *testing.T) {
+	ps := clusteredParticles()
+	for i := range ps {
+		ps[i].Mass = 1 + float64(i%3)
+	}
+	root := MakeCells(ps, Vertical)
+
+	g := Gravity{G: 2, Theta: 0, Softening: 0.01}
+	if err := gravityError(root, &g); err > 1e-10 {
+		t.Fatalf("Theta 0 should give the direct sum, but the error is %v", err)
+	}
+
+	g.Theta = 0.6
+	monopole := gravityError(root, &g)
+	if monopole > 0.02 {
+		t.Fatalf("monopole error too big: %v", monopole)
+	}
+
+	g.Quadrupole = true
+	quadrupole := gravityError(root, &g)
+	t.Logf("mean relative error monopole %v quadrupole %v", monopole, quadrupole)
+	if quadrupole > monopole/2 {
+		t.Fatalf("quadrupole error %v should be much smaller than the monopole error %v", quadrupole, monopole)
+	}
+
+	if math.Abs(root.Mass-massOf(ps)) > 1e-9 {
+		t.Fatalf("root mass %v, but the particles have %v", root.Mass, massOf(ps))
+	}
+}
+
+func massOf(particles []Particle) float64 {
+	m := 0.0
+	for i := range particles {
+		m += particles[i].Mass
+	}
+	return m
+}
+
+func TestGravityConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gravity.sph-config")
+	for _, theta := range []string{"0", "-0.5", "1", "1.5"} {
+		source := "[[Simulation]]\n[Gravity]\nG 1\nTheta " + theta + "\n"
+		if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := MakeConfigFromFile(path); err == nil {
+			t.Fatalf("expected an error for Theta %v", theta)
+		}
+	}
+}
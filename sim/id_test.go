@@ -0,0 +1,59 @@
+package sim
+
+import (
+	"testing"
+)
+
+func checkIDs(sim *Simulation, t *testing.T) {
+	n := len(sim.Root.Particles)
+	if sim.nextID != n+1 {
+		t.Fatalf("expected IDs 1 to %v, next ID is %v", n, sim.nextID)
+	}
+
+	for id := 1; id <= n; id++ {
+		i := sim.IndexOf(id)
+		if i < 0 || sim.Root.Particles[i].ID != id {
+			t.Fatalf("ID %v maps to index %v", id, i)
+		}
+	}
+}
+
+func TestIDsSurviveRebuildAndSpawning(t *testing.T) {
+	conf := MakeConfig()
+	conf.Start = []ParticleSource{UniformRectSpawner{
+		UpperLeft:  Vec2{0.1, 0.1},
+		LowerRight: Vec2{0.6, 0.9},
+		NParticles: 500,
+	}}
+	conf.Sources = []ParticleSource{&PointSource{origin: Vec2{0.3, 0.3}, rate: 200}}
+	conf.DeltaTHalf = 0.005
+
+	sim := MakeSimulationFromConf(conf)
+	checkIDs(&sim, t)
+
+	const id = 17
+	previous := sim.ParticleByID(id).Pos
+
+	for range 5 {
+		sim.Step()
+		checkIDs(&sim, t)
+
+		p := sim.ParticleByID(id)
+		if Dist(p.Pos, previous) > 0.05 {
+			t.Fatalf("particle %v jumped from %v to %v, the ID is not followed", id, previous, p.Pos)
+		}
+		previous = p.Pos
+
+		// neighbour IDs point to the same particles as the indices
+		nn := sim.Neighbours.Of(sim.IndexOf(id))
+		for j, nid := range sim.NeighbourIDs(id, nil) {
+			if sim.IndexOf(nid) != int(nn.Index[j]) {
+				t.Fatalf("neighbour %v of particle %v has ID %v at index %v, expected index %v", j, id, nid, sim.IndexOf(nid), nn.Index[j])
+			}
+		}
+	}
+
+	if len(sim.Root.Particles) <= 500 {
+		t.Fatalf("the source did not spawn any particles")
+	}
+}
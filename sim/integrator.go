@@ -0,0 +1,172 @@
+/* Time integrators for Simulation.Step()
+
+All of them need one force calculation per step. Step() calls CalculateForces()
+before the first step, so VDot and EDot belong to the current state when an
+integrator starts (for Leapfrog they are from the middle of the last step, it
+only uses them for the prediction). CalculateForces() uses the predicted
+VPred and EPred, the integrators have to set them before.
+
+Symplectic integrators keep the energy error bounded for conservative forces,
+the others drift. With viscosity SPH isn't conservative anyways, but the
+difference shows in the energy drift of the same scene.
+
+The hierarchical time steps in time-bins.go have their own leapfrog and
+ignore the integrator.
+*/
+
+package sim
+
+type Integrator interface {
+	Step(sim *Simulation, dt float64) // Advances all particles by dt
+	Symplectic() bool
+}
+
+var Integrators = map[string]Integrator{
+	"Leapfrog":          Leapfrog{},
+	"VelocityVerlet":    VelocityVerlet{},
+	"RungeKutta":        RungeKutta{},
+	"SemiImplicitEuler": SemiImplicitEuler{},
+}
+
+// Drift-kick-drift leapfrog, 2nd order and symplectic. The default.
+type Leapfrog struct{}
+
+func (Leapfrog) Symplectic() bool { return true }
+
+func (Leapfrog) Step(sim *Simulation, dt float64) {
+	dtHalf := dt / 2
+
+	// drift 1 for leapfrog dt/2
+	for i, _ := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		vdt := p.Vel.Mul(dtHalf)
+		p.Pos = p.Pos.Add(&vdt)
+
+		adt := p.VDot.Mul(dtHalf)
+		p.VPred = p.Vel.Add(&adt)
+		p.EPred = p.E + p.EDot*dtHalf
+	}
+
+	sim.CalculateForces()
+
+	// kick dt
+	for i, _ := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		adt := p.VDot.Mul(dt)
+		p.Vel = p.Vel.Add(&adt)
+		p.E = p.E + p.EDot*dt
+	}
+
+	// drift 2 for leapfrog dt/2
+	for i, _ := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		vdt := p.Vel.Mul(dtHalf)
+		p.Pos = p.Pos.Add(&vdt)
+	}
+}
+
+// Kick-drift-kick, 2nd order and symplectic. The forces at the end of the
+// step are the ones at the start of the next.
+type VelocityVerlet struct{}
+
+func (VelocityVerlet) Symplectic() bool { return true }
+
+func (VelocityVerlet) Step(sim *Simulation, dt float64) {
+	dtHalf := dt / 2
+
+	// kick dt/2 and drift dt
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		adt := p.VDot.Mul(dtHalf)
+		p.Vel = p.Vel.Add(&adt)
+		p.E = p.E + p.EDot*dtHalf
+
+		vdt := p.Vel.Mul(dt)
+		p.Pos = p.Pos.Add(&vdt)
+
+		p.VPred = p.Vel.Add(&adt)
+		p.EPred = p.E + p.EDot*dtHalf
+	}
+
+	sim.CalculateForces()
+
+	// kick dt/2 with the new forces
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		adt := p.VDot.Mul(dtHalf)
+		p.Vel = p.Vel.Add(&adt)
+		p.E = p.E + p.EDot*dtHalf
+	}
+}
+
+// Heun's method, a 2nd order Runge-Kutta as predictor-corrector. Not symplectic.
+//
+//	predict: x1 = x0 + v0 dt,             v1 = v0 + a0 dt
+//	correct: x  = x0 + (v0 + v1) dt / 2,  v  = v0 + (a0 + a1) dt / 2
+//
+// v1 is kept in VPred and a0 = (v1 - v0) / dt, so no extra storage is needed.
+// The forces for the next step are the ones of the prediction.
+type RungeKutta struct{}
+
+func (RungeKutta) Symplectic() bool { return false }
+
+func (RungeKutta) Step(sim *Simulation, dt float64) {
+
+	// predict
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		vdt := p.Vel.Mul(dt)
+		p.Pos = p.Pos.Add(&vdt)
+
+		adt := p.VDot.Mul(dt)
+		p.VPred = p.Vel.Add(&adt)
+		p.EPred = p.E + p.EDot*dt
+	}
+
+	sim.CalculateForces()
+
+	// correct
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		// (v1 - v0) dt / 2 = a0 dt^2 / 2
+		dv := p.VPred.Sub(&p.Vel)
+		dx := dv.Mul(dt / 2)
+		p.Pos = p.Pos.Add(&dx)
+
+		vMid := p.Vel.Add(&p.VPred).Mul(0.5)
+		adt := p.VDot.Mul(dt / 2)
+		p.Vel = vMid.Add(&adt)
+		p.E = 0.5*(p.E+p.EPred) + p.EDot*dt/2
+	}
+}
+
+// Kick then drift with the new velocity, 1st order but symplectic. Cheap and
+// robust enough for quick tests.
+type SemiImplicitEuler struct{}
+
+func (SemiImplicitEuler) Symplectic() bool { return true }
+
+func (SemiImplicitEuler) Step(sim *Simulation, dt float64) {
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+
+		adt := p.VDot.Mul(dt)
+		p.Vel = p.Vel.Add(&adt)
+		p.E = p.E + p.EDot*dt
+
+		vdt := p.Vel.Mul(dt)
+		p.Pos = p.Pos.Add(&vdt)
+
+		p.VPred = p.Vel
+		p.EPred = p.E
+	}
+
+	// forces for the next step
+	sim.CalculateForces()
+}
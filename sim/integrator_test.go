@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+// without internal energy there is no pressure and moving all particles
+// together has no viscosity, so they just fall with Config.Acceleration
+func TestIntegratorsFreeFall(t *testing.T) {
+	for name, integrator := range Integrators {
+		conf := MakeConfig()
+		conf.Start = []ParticleSource{UniformRectSpawner{
+			UpperLeft:  Vec2{0.1, 0.1},
+			LowerRight: Vec2{0.4, 0.4},
+			NParticles: 200,
+		}}
+		conf.Integrator = integrator
+		conf.DeltaTHalf = 0.01
+		conf.Acceleration = Vec2{0, 1}
+
+		sim := MakeSimulationFromConf(conf)
+		v0 := Vec2{0.5, -1}
+		start := make(map[int]Vec2)
+		for i := range sim.Root.Particles {
+			p := &sim.Root.Particles[i]
+			p.E = 0
+			p.Vel = v0
+			start[p.ID] = p.Pos
+		}
+
+		for range 20 {
+			sim.Step()
+		}
+
+		// the 2nd order ones are exact for constant acceleration, Euler is
+		// off by a dt t / 2
+		tol := 1e-9
+		if name == "SemiImplicitEuler" {
+			tol = 2 * conf.DeltaTHalf * sim.Time
+		}
+
+		a := conf.Acceleration
+		for i := range sim.Root.Particles {
+			p := &sim.Root.Particles[i]
+			x0 := start[p.ID]
+			want := Vec2{
+				x0.X + v0.X*sim.Time + a.X*sim.Time*sim.Time/2,
+				x0.Y + v0.Y*sim.Time + a.Y*sim.Time*sim.Time/2,
+			}
+			if Dist(want, p.Pos) > tol {
+				t.Fatalf("%v: particle %v at %v, expected %v", name, p.ID, p.Pos, want)
+			}
+			wantVel := Vec2{v0.X + a.X*sim.Time, v0.Y + a.Y*sim.Time}
+			if Dist(wantVel, p.Vel) > 1e-9 {
+				t.Fatalf("%v: particle %v has velocity %v, expected %v", name, p.ID, p.Vel, wantVel)
+			}
+			if p.E != 0 {
+				t.Fatalf("%v: particle %v got internal energy %v", name, p.ID, p.E)
+			}
+		}
+	}
+}
+
+// a blob of gas expanding, the SPH energy isn't exactly conserved by itself,
+// but all integrators have to end up with about the same energy
+func TestIntegratorsEnergy(t *testing.T) {
+	energies := make(map[string]float64)
+	for name, integrator := range Integrators {
+		conf := adaptiveTestConfig()
+		conf.AdaptiveTimeStep = false
+		conf.DeltaTHalf = 0.002
+		conf.Integrator = integrator
+
+		sim := MakeSimulationFromConf(conf)
+		for range 20 {
+			sim.Step()
+		}
+		energies[name] = sim.TotalEnergy() + sim.TotalKineticEnergy()
+	}
+
+	want := energies["Leapfrog"]
+	for name, energy := range energies {
+		if math.IsNaN(energy) || math.Abs(energy-want) > 1e-4*want {
+			t.Fatalf("%v ends with energy %v, Leapfrog with %v", name, energy, want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+/* 2D SPH kernels
+
+All kernels are written in q = r/H where H is the support radius (the distance
+to the farthest nearest neighbour), so W is 0 for q >= 1:
+
+	W(r, H)     = FPrefactor  * F(q)  / H^2
+	dW/dr(r, H) = DFPrefactor * DF(q) / H^3
+
+They are normalised to 1 over the disk of radius H. The splines are usually
+given with the smoothing length h = H/2 (M4), H/2.5 (M5) or H/3 (M6), the
+factors are already absorbed into F and the prefactors.
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+type Kernel struct {
+	F           func(q float64) float64
+	FPrefactor  float64
+	DF          func(q float64) float64
+	DFPrefactor float64
+}
+
+// Kernels by their name in the config file
+var Kernels = map[string]Kernel{
+	"M4":         M4Kernel2D,
+	"M5":         M5Kernel2D,
+	"M6":         M6Kernel2D,
+	"WendlandC2": WendlandC2Kernel2D,
+	"WendlandC4": WendlandC4Kernel2D,
+	"WendlandC6": WendlandC6Kernel2D,
+	"Gaussian":   GaussianKernel2D,
+
+	// old names
+	"Monahan":   Monahan2D,
+	"Wendtland": Wendtland2D,
+}
+
+var TopHat2D = Kernel{
+	F: func(q float64) float64 {
+		return 1
+	},
+
+	FPrefactor: 1 / math.Pi,
+
+	// the derivative is a delta distribution at q = 1, 0 inside
+	DF: func(q float64) float64 {
+		return 0
+	},
+
+	DFPrefactor: 1,
+}
+
+// Monahan's cubic spline
+var M4Kernel2D = Kernel{
+	F: func(q float64) float64 {
+		if q < 0.5 {
+			return q*q*q - q*q + 1.0/6
+		}
+		if q < 1 {
+			return (1 - q) * (1 - q) * (1 - q) / 3
+		}
+		return 0
+	},
+
+	FPrefactor: 6 * 40 / (math.Pi * 7),
+
+	DF: func(q float64) float64 {
+		if q < 0.5 {
+			return (3*q*q - 2*q)
+		}
+		if q < 1 {
+			return -(1 - q) * (1 - q)
+		}
+		return 0
+	},
+
+	DFPrefactor: 6 * 40 / (math.Pi * 7),
+}
+
+// Quartic spline, h = H/2.5
+var M5Kernel2D = Kernel{
+	F: func(q float64) float64 {
+		return pow4(1-q) - 5*pow4(0.6-q) + 10*pow4(0.2-q)
+	},
+
+	FPrefactor: 96 * math.Pow(2.5, 6) / (1199 * math.Pi),
+
+	DF: func(q float64) float64 {
+		return -4*pow3(1-q) + 20*pow3(0.6-q) - 40*pow3(0.2-q)
+	},
+
+	DFPrefactor: 96 * math.Pow(2.5, 6) / (1199 * math.Pi),
+}
+
+// Quintic spline, h = H/3
+var M6Kernel2D = Kernel{
+	F: func(q float64) float64 {
+		return pow5(1-q) - 6*pow5(2.0/3-q) + 15*pow5(1.0/3-q)
+	},
+
+	FPrefactor: 7 * math.Pow(3, 7) / (478 * math.Pi),
+
+	DF: func(q float64) float64 {
+		return -5*pow4(1-q) + 30*pow4(2.0/3-q) - 75*pow4(1.0/3-q)
+	},
+
+	DFPrefactor: 7 * math.Pow(3, 7) / (478 * math.Pi),
+}
+
+var WendlandC2Kernel2D = Kernel{
+	F: func(q float64) float64 {
+		return pow4(1-q) * (1 + 4*q)
+	},
+
+	FPrefactor: 7 / math.Pi,
+
+	DF: func(q float64) float64 {
+		return -20 * q * pow3(1-q)
+	},
+
+	DFPrefactor: 7 / math.Pi,
+}
+
+var WendlandC4Kernel2D = Kernel{
+	F: func(q float64) float64 {
+		return pow3(1-q) * pow3(1-q) * (1 + 6*q + 35.0/3*q*q)
+	},
+
+	FPrefactor: 9 / math.Pi,
+
+	DF: func(q float64) float64 {
+		return -56.0 / 3 * q * (1 + 5*q) * pow5(1-q)
+	},
+
+	DFPrefactor: 9 / math.Pi,
+}
+
+var WendlandC6Kernel2D = Kernel{
+	F: func(q float64) float64 {
+		return pow4(1-q) * pow4(1-q) * (1 + 8*q + 25*q*q + 32*q*q*q)
+	},
+
+	FPrefactor: 78 / (7 * math.Pi),
+
+	DF: func(q float64) float64 {
+		return -22 * q * (1 + 7*q + 16*q*q) * pow4(1-q) * pow3(1-q)
+	},
+
+	DFPrefactor: 78 / (7 * math.Pi),
+}
+
+// exp(-r^2/h^2) cut off at r = 3h = H and normalised again
+var GaussianKernel2D = Kernel{
+	F: func(q float64) float64 {
+		if q >= 1 {
+			return 0
+		}
+		return math.Exp(-9 * q * q)
+	},
+
+	FPrefactor: 9 / (math.Pi * (1 - math.Exp(-9))),
+
+	DF: func(q float64) float64 {
+		if q >= 1 {
+			return 0
+		}
+		return -18 * q * math.Exp(-9*q*q)
+	},
+
+	DFPrefactor: 9 / (math.Pi * (1 - math.Exp(-9))),
+}
+
+// old names
+var Monahan2D = M4Kernel2D
+var Wendtland2D = WendlandC2Kernel2D
+
+// x^n for x > 0, 0 otherwise. That is all the splines need.
+func pow3(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return x * x * x
+}
+
+func pow4(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return x * x * x * x
+}
+
+func pow5(x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return x * x * x * x * x
+}
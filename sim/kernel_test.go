@@ -0,0 +1,71 @@
+package sim
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// integral of W over the disk of radius H = 1
+func TestKernelNormalisation(t *testing.T) {
+	n := 100000
+	for name, kernel := range Kernels {
+		integral := 0.0
+		for i := range n {
+			q := (float64(i) + 0.5) / float64(n)
+			integral += kernel.FPrefactor * kernel.F(q) * 2 * math.Pi * q / float64(n)
+		}
+		if math.Abs(integral-1) > 1e-6 {
+			t.Fatalf("kernel %v integrates to %v", name, integral)
+		}
+	}
+}
+
+// DF has to be the derivative of F, compared with central differences
+func TestKernelGradients(t *testing.T) {
+	eps := 1e-6
+	for name, kernel := range Kernels {
+		for i := 1; i < 1000; i++ {
+			q := float64(i) / 1000
+			want := kernel.FPrefactor * (kernel.F(q+eps) - kernel.F(q-eps)) / (2 * eps)
+			got := kernel.DFPrefactor * kernel.DF(q)
+			if math.Abs(got-want) > 1e-5*(1+math.Abs(want)) {
+				t.Fatalf("kernel %v at q=%v: gradient %v, numerically %v", name, q, got, want)
+			}
+		}
+	}
+}
+
+func TestKernelSupport(t *testing.T) {
+	for name, kernel := range Kernels {
+		for _, q := range []float64{1, 1.1, 2} {
+			if kernel.F(q) != 0 || kernel.DF(q) != 0 {
+				t.Fatalf("kernel %v is not 0 at q=%v: %v %v", name, q, kernel.F(q), kernel.DF(q))
+			}
+		}
+		if kernel.F(0) <= 0 || math.Abs(kernel.DF(0)) > 1e-12 {
+			t.Fatalf("kernel %v at q=0: %v %v", name, kernel.F(0), kernel.DF(0))
+		}
+	}
+
+	if TopHat2D.DF(0.5) != 0 {
+		t.Fatalf("TopHat2D gradient is %v inside", TopHat2D.DF(0.5))
+	}
+}
+
+func TestKernelConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kernel.conf")
+	err := os.WriteFile(path, []byte("[[Simulation]]\n[Config]\nKernel WendlandC4\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := MakeConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("could not parse kernel config: %v", err)
+	}
+	if conf.Kernel.FPrefactor != WendlandC4Kernel2D.FPrefactor {
+		t.Fatalf("expected WendlandC4, got prefactor %v", conf.Kernel.FPrefactor)
+	}
+}
@@ -8,27 +8,139 @@ import (
 // TODO: remove
 var _ = fmt.Print
 
+// Nearest neighbours of all particles as structure of arrays, K entries per
+// particle. They are kept out of Particle, so that the loops which only
+// need the particle itself don't have to drag them through the cache.
+type Neighbours struct {
+	K     int       // number of neighbours per particle
+	Index []int32   // index into the particles of the root cell, -1 if not found
+	Dists []float64 // sorted from the farthest to the nearest
+	Pos   []Vec2    // keep track of position,  we need to know because of periodic b.c.
+}
+
+// The nearest neighbours of one particle, slices into Neighbours
+type NNList struct {
+	Index []int32
+	Dists []float64
+	Pos   []Vec2
+}
+
+// Makes room for k neighbours of n particles
+func (nb *Neighbours) Resize(n, k int) {
+	if cap(nb.Index) < n*k {
+		nb.Index = make([]int32, n*k)
+		nb.Dists = make([]float64, n*k)
+		nb.Pos = make([]Vec2, n*k)
+	}
+	nb.K = k
+	nb.Index = nb.Index[:n*k]
+	nb.Dists = nb.Dists[:n*k]
+	nb.Pos = nb.Pos[:n*k]
+}
+
+// Number of particles there is room for
+func (nb *Neighbours) Len() int {
+	if nb.K == 0 {
+		return 0
+	}
+	return len(nb.Index) / nb.K
+}
+
+// The neighbours of particle i
+func (nb *Neighbours) Of(i int) NNList {
+	start, end := i*nb.K, (i+1)*nb.K
+	return NNList{
+		Index: nb.Index[start:end:end],
+		Dists: nb.Dists[start:end:end],
+		Pos:   nb.Pos[start:end:end],
+	}
+}
+
+// A list for k neighbours of a single particle, e.g. for a search outside
+// of the Simulation
+func MakeNNList(k int) NNList {
+	return NNList{
+		Index: make([]int32, k),
+		Dists: make([]float64, k),
+		Pos:   make([]Vec2, k),
+	}
+}
+
 // recursively find all nearest neighbors of a particle based on position
-// make sure you call it on the top/root Cell!!
+// and store them in nn. make sure you call it on the top/root Cell!!
 //
 // TODO: implement it using a loop
-func (particle *Particle) FindNearestNeighbours(root *Cell) {
-	particle.NNQueueInitSentinel()
-	particle.findNNRec(root, Vec2{0, 0})
-
-	// make dists use Sqrt
-	for i := range NN_SIZE {
-		particle.NNDists[i] = math.Sqrt(particle.NNDists[i])
-	}
+func (particle *Particle) FindNearestNeighbours(root *Cell, nn NNList) {
+	root.findNN(particle.Pos, particle, particle.H, nn, []Vec2{{0, 0}})
+	particle.H = nn.Farthest()
 }
 
 // Periodic version
 // assuming particles are between x = HorPeriodic, y = VertPeriodic
 // check for min/max float -> Open Boundaries
-func (particle *Particle) FindNearestNeighboursPeriodic(root *Cell, HorPeriodic, VertPeriodic [2]float64) {
+func (particle *Particle) FindNearestNeighboursPeriodic(root *Cell, nn NNList, HorPeriodic, VertPeriodic [2]float64) {
+	offsets, n := periodicOffsets(HorPeriodic, VertPeriodic)
+	root.findNN(particle.Pos, particle, particle.H, nn, offsets[:n])
+	particle.H = nn.Farthest()
+}
 
-	particle.NNQueueInitSentinel()
+// The len(nn.Index) nearest particles to any point pos, e.g. for probes or
+// interpolation on a grid. nn is filled like for the particle search: Index
+// into the particles of the root cell, Dists sorted from the farthest to the
+// nearest. A particle exactly at pos is included with distance 0.
+func (root *Cell) NearestNeighbours(pos Vec2, nn NNList) {
+	root.findNN(pos, nil, 0, nn, []Vec2{{0, 0}})
+}
 
+// Periodic version, see FindNearestNeighboursPeriodic()
+func (root *Cell) NearestNeighboursPeriodic(pos Vec2, nn NNList, HorPeriodic, VertPeriodic [2]float64) {
+	offsets, n := periodicOffsets(HorPeriodic, VertPeriodic)
+	root.findNN(pos, nil, 0, nn, offsets[:n])
+}
+
+// The search is exact: first it only looks within NN_HINT_FACTOR times the
+// radius hint (for particles H of the last search), which prunes most of the
+// tree. If that does not give the full set of neighbours it searches again
+// without a limit. Only if there are not enough particles at all, entries
+// stay at Index -1. The particle self (can be nil) is skipped.
+func (root *Cell) findNN(pos Vec2, self *Particle, hint float64, nn NNList, offsets []Vec2) {
+
+	full := false
+	if hint > 0 {
+		nn.InitSentinel(NN_HINT_FACTOR * hint)
+		root.findNNImages(pos, self, nn, offsets)
+		full = nn.Full()
+	}
+
+	if !full {
+		nn.InitSentinel(math.Inf(1))
+		root.findNNImages(pos, self, nn, offsets)
+	}
+
+	// make dists use Sqrt
+	for i := range nn.Dists {
+		nn.Dists[i] = math.Sqrt(nn.Dists[i])
+	}
+}
+
+// Searches the periodic images, offsets[0] has to be 0 0.
+// The other images are only visited if the search radius reaches over the
+// boundary into the root cell from there, which is rare for particles
+// inside the domain.
+func (root *Cell) findNNImages(pos Vec2, self *Particle, nn NNList, offsets []Vec2) {
+	root.findNNRec(pos, self, nn, offsets[0])
+
+	for _, offset := range offsets[1:] {
+		image := pos.Add(&offset)
+		if root.DistSquared(&image) < nn.PeekKey() {
+			root.findNNRec(image, self, nn, offset)
+		}
+	}
+}
+
+// Offsets of the periodic images that have to be searched, the first one is
+// always 0 0. Open boundaries have no images in that direction.
+func periodicOffsets(HorPeriodic, VertPeriodic [2]float64) (offsets [9]Vec2, n int) {
 	iStart := -1
 	jStart := -1
 	iEnd := 1
@@ -54,30 +166,32 @@ func (particle *Particle) FindNearestNeighboursPeriodic(root *Cell, HorPeriodic,
 		}
 	}
 
+	offsets[0] = Vec2{0, 0}
+	n = 1
 	for i := iStart; i <= iEnd; i++ {
 		for j := jStart; j <= jEnd; j++ {
-			particle.findNNRec(root, Vec2{float64(i) * deltaX, float64(j) * deltaY})
+			if i == 0 && j == 0 {
+				continue
+			}
+			offsets[n] = Vec2{float64(i) * deltaX, float64(j) * deltaY}
+			n++
 		}
 	}
-
-	// make dists use Sqrt
-	for i := range NN_SIZE {
-		particle.NNDists[i] = math.Sqrt(particle.NNDists[i])
-	}
+	return offsets, n
 }
 
 // TODO: @Speed fix Sqrts
-func (particle *Particle) findNNRec(root *Cell, offset Vec2) {
-
-	pos := particle.Pos.Add(&offset)
+//
+// pos is the position of the image already shifted by offset
+func (root *Cell) findNNRec(pos Vec2, self *Particle, nn NNList, offset Vec2) {
 
 	if root.Upper == nil && root.Lower == nil {
 		for i := range root.Particles {
 			d2 := DistSq(pos, root.Particles[i].Pos)
 
 			// if the dist is lower than max dist and the particle is not itself!
-			if d2 < particle.NNQueuePeekKey() && particle != &root.Particles[i] {
-				particle.NNQueueInsert(d2, &root.Particles[i], root.Particles[i].Pos.Sub(&offset))
+			if d2 < nn.PeekKey() && self != &root.Particles[i] {
+				nn.Insert(d2, int32(root.Offset+i), root.Particles[i].Pos.Sub(&offset))
 			}
 		}
 		return
@@ -88,21 +202,21 @@ func (particle *Particle) findNNRec(root *Cell, offset Vec2) {
 		distLower := Dist(root.Lower.BCenter, pos)
 
 		// sqrt call ...
-		maxDist := math.Sqrt(particle.NNQueuePeekKey())
+		maxDist := math.Sqrt(nn.PeekKey())
 
 		if distLower < distUpper {
 			if distLower-root.Lower.BRadius < maxDist {
-				particle.findNNRec(root.Lower, offset)
+				root.Lower.findNNRec(pos, self, nn, offset)
 			}
 			if distUpper-root.Upper.BRadius < maxDist {
-				particle.findNNRec(root.Upper, offset)
+				root.Upper.findNNRec(pos, self, nn, offset)
 			}
 		} else {
 			if distUpper-root.Upper.BRadius < maxDist {
-				particle.findNNRec(root.Upper, offset)
+				root.Upper.findNNRec(pos, self, nn, offset)
 			}
 			if distLower-root.Lower.BRadius < maxDist {
-				particle.findNNRec(root.Lower, offset)
+				root.Lower.findNNRec(pos, self, nn, offset)
 			}
 
 		}
@@ -110,56 +224,66 @@ func (particle *Particle) findNNRec(root *Cell, offset Vec2) {
 	}
 
 	if root.Upper != nil {
-		particle.findNNRec(root.Upper, offset)
+		root.Upper.findNNRec(pos, self, nn, offset)
 	}
 
 	if root.Lower != nil {
-		particle.findNNRec(root.Lower, offset)
+		root.Lower.findNNRec(pos, self, nn, offset)
 	}
 }
 
-// not used/ not sure if it is correct?
-// dist squared to cell
+// dist squared to the bounds of the cell, 0 if inside
 func (cell *Cell) DistSquared(to *Vec2) float64 {
 	d1 := to.Sub(&cell.UpperRight)
 	d2 := cell.LowerLeft.Sub(to)
-	maxx := math.Max(d1.X, d2.X)
-	maxy := math.Max(d1.Y, d2.Y)
+	maxx := max(d1.X, d2.X, 0)
+	maxy := max(d1.Y, d2.Y, 0)
 	return maxx*maxx + maxy*maxy
 }
 
-func (p *Particle) NNQueuePeekKey() float64 {
-	return p.NNDists[0]
+func (nn NNList) PeekKey() float64 {
+	return nn.Dists[0]
+}
+
+// Distance to the farthest neighbour that was found. It is Dists[0] unless
+// there are less particles than NNSize in the whole simulation, +Inf if there
+// are none.
+func (nn NNList) Farthest() float64 {
+	for j := range nn.Dists {
+		if nn.Index[j] >= 0 {
+			return nn.Dists[j]
+		}
+	}
+	return math.Inf(1)
 }
 
 // This is actually faster than the heapque. it's probably beacuse we only have 32 NN's
-// TODO: compare for other NN_SIZEs than 32
+// TODO: compare for other NNSizes than 32
 //
 //	using copy() is actually slower, because it is not inlined  anymore by the compiler
-func (p *Particle) NNQueueInsert(dist float64, neighbour *Particle, realPos Vec2) {
-	var i uint8 = 1
-	for ; i < NN_SIZE && p.NNDists[i] > dist; i++ {
-		p.NNDists[i-1] = p.NNDists[i]
-		p.NearestNeighbours[i-1] = p.NearestNeighbours[i]
-		p.NNPos[i-1] = p.NNPos[i]
-	}
-	//copy(p.NNDists[:i-1], p.NNDists[1:i])
-	//copy(p.NearestNeighbours[:i-1], p.NearestNeighbours[1:i])
-	//copy(p.NNPos[:i-1], p.NNPos[1:i])
-
-	p.NNDists[i-1] = dist
-	p.NearestNeighbours[i-1] = neighbour
-	p.NNPos[i-1] = realPos
-}
-
-func (p *Particle) NNQueueInitSentinel() {
-	for i := range NN_SIZE {
-		// This assumes the particles don't move more than
-		// this value can be optimized, but actually might introduce errors in low density regions
-		// @Inclomple be careful when changing coordinate system
-		// TODO: make sure it's not too bad
-		//
-		//p.NNDists[i] = math.MaxFloat64
-		p.NNDists[i] += 0.4
+func (nn NNList) Insert(dist float64, neighbour int32, realPos Vec2) {
+	i := 1
+	for ; i < len(nn.Dists) && nn.Dists[i] > dist; i++ {
+		nn.Dists[i-1] = nn.Dists[i]
+		nn.Index[i-1] = nn.Index[i]
+		nn.Pos[i-1] = nn.Pos[i]
 	}
+
+	nn.Dists[i-1] = dist
+	nn.Index[i-1] = neighbour
+	nn.Pos[i-1] = realPos
+}
+
+// Only neighbours closer than radius will be inserted, math.Inf(1) for no
+// limit. (Dists are squared during the search.)
+func (nn NNList) InitSentinel(radius float64) {
+	for i := range nn.Dists {
+		nn.Dists[i] = radius * radius
+		nn.Index[i] = -1
+	}
+}
+
+// All entries are filled, the first one is the farthest so it's the last to go
+func (nn NNList) Full() bool {
+	return nn.Index[0] >= 0
 }
@@ -0,0 +1,271 @@
+package sim
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// Sorted distances to all other particles and their periodic images, the
+// brute force reference for the neighbour search
+func bruteForceDists(particles []Particle, i int, HorPeriodic, VertPeriodic [2]float64, dists []float64) []float64 {
+	offsets, n := periodicOffsets(HorPeriodic, VertPeriodic)
+
+	dists = dists[:0]
+	for j := range particles {
+		if j == i {
+			continue
+		}
+		for _, offset := range offsets[:n] {
+			pos := particles[i].Pos.Add(&offset)
+			dists = append(dists, Dist(pos, particles[j].Pos))
+		}
+	}
+	slices.Sort(dists)
+	return dists
+}
+
+// compares the neighbours of all particles with a brute force search
+func checkNeighbours(sim *Simulation, t *testing.T) {
+	particles := sim.Root.Particles
+	var dists []float64
+
+	for i := range particles {
+		nn := sim.Neighbours.Of(i)
+
+		for j := range nn.Index {
+			if nn.Index[j] < 0 {
+				t.Fatalf("particle %v: neighbour %v not found", i, j)
+			}
+			d := Dist(particles[i].Pos, nn.Pos[j])
+			if math.Abs(d-nn.Dists[j]) > 1e-12 {
+				t.Fatalf("particle %v: neighbour %v has distance %v, but %v is stored", i, nn.Index[j], d, nn.Dists[j])
+			}
+		}
+
+		dists = bruteForceDists(particles, i, sim.Config.HorPeriodicity, sim.Config.VertPeriodicity, dists)
+
+		k := sim.Config.NNSize
+		for j := range k {
+			if math.Abs(dists[j]-nn.Dists[k-1-j]) > 1e-12 {
+				t.Fatalf("particle %v: neighbour %v at %v, but brute force gives %v", i, k-1-j, nn.Dists[k-1-j], dists[j])
+			}
+		}
+		if particles[i].H != nn.Dists[0] {
+			t.Fatalf("particle %v: H %v is not the distance to the farthest neighbour %v", i, particles[i].H, nn.Dists[0])
+		}
+	}
+}
+
+func TestNeighbourIndices(t *testing.T) {
+	for _, k := range []int{2, 7, NN_SIZE, 100} {
+		testNeighbourIndices(k, t)
+	}
+}
+
+func testNeighbourIndices(k int, t *testing.T) {
+	conf := MakeConfig()
+	conf.NNSize = k
+	conf.Start = []ParticleSource{UniformRectSpawner{
+		UpperLeft:  Vec2{0.1, 0.1},
+		LowerRight: Vec2{0.6, 0.9},
+		NParticles: 1000,
+	}}
+	conf.TreeSplit = MedianSplit
+	conf.TreeRefit = true
+
+	sim := MakeSimulationFromConf(conf)
+	sim.FindNearestNeighbours()
+	checkNeighbours(&sim, t)
+
+	// the offsets of the cells have to stay right after a refit
+	for i := range sim.Root.Particles {
+		sim.Root.Particles[i].Pos.X += 0.01 * float64(i%7)
+	}
+	sim.UpdateTree()
+	sim.FindNearestNeighbours()
+	checkNeighbours(&sim, t)
+}
+
+// A sparse big domain, where particles move much more than their H between
+// searches, so the hint radius is wrong most of the time
+func TestNeighboursSparse(t *testing.T) {
+	conf := MakeConfig()
+	conf.Start = []ParticleSource{
+		UniformRectSpawner{UpperLeft: Vec2{0, 0}, LowerRight: Vec2{100, 100}, NParticles: 300},
+		UniformRectSpawner{UpperLeft: Vec2{10, 10}, LowerRight: Vec2{11, 11}, NParticles: 100},
+	}
+	conf.HorPeriodicity = [2]float64{0, 100}
+	conf.VertPeriodicity = [2]float64{0, 100}
+
+	sim := MakeSimulationFromConf(conf)
+	sim.FindNearestNeighbours()
+	checkNeighbours(&sim, t)
+
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		p.Pos.X = math.Mod(p.Pos.X+float64(i%13)*3.7, 100)
+		p.Pos.Y = math.Mod(p.Pos.Y+float64(i%5)*0.3, 100)
+	}
+	sim.BuildTree()
+	sim.FindNearestNeighbours()
+	checkNeighbours(&sim, t)
+}
+
+// less particles than neighbours, the search must not panic
+func TestNeighboursTooFewParticles(t *testing.T) {
+	conf := MakeConfig()
+	conf.Start = []ParticleSource{UniformRectSpawner{LowerRight: Vec2{1, 1}, NParticles: 10}}
+
+	sim := MakeSimulationFromConf(conf)
+	sim.FindNearestNeighbours()
+
+	nn := sim.Neighbours.Of(0)
+	found := 0
+	for _, j := range nn.Index {
+		if j >= 0 {
+			found++
+		}
+	}
+	if found != 9 {
+		t.Fatalf("expected all 9 other particles as neighbours, got %v", found)
+	}
+
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		rho := Density2D(i, &sim, sim.Config.Kernel)
+		if math.IsInf(p.H, 0) || !(rho > 0) || math.IsInf(rho, 0) {
+			t.Fatalf("particle %v has H %v and density %v", i, p.H, rho)
+		}
+	}
+
+	sim.Step()
+	for i, p := range sim.Root.Particles {
+		if math.IsNaN(p.P) || math.IsNaN(p.VDot.X) || math.IsNaN(p.VDot.Y) || math.IsNaN(p.EDot) {
+			t.Fatalf("particle %v has pressure %v, acceleration %v and EDot %v", i, p.P, p.VDot, p.EDot)
+		}
+	}
+}
+
+func TestNeighboursIsolatedParticle(t *testing.T) {
+	for _, gradH := range []bool{false, true} {
+		conf := MakeConfig()
+		conf.GradH = gradH
+		conf.Start = []ParticleSource{UniformRectSpawner{LowerRight: Vec2{1, 1}, NParticles: 1}}
+
+		sim := MakeSimulationFromConf(conf)
+		for range 3 {
+			sim.Step()
+		}
+
+		p := &sim.Root.Particles[0]
+		if !(p.H > 0) || math.IsInf(p.H, 0) || !(p.Rho > 0) || math.IsInf(p.Rho, 0) {
+			t.Fatalf("GradH %v: isolated particle has H %v and density %v", gradH, p.H, p.Rho)
+		}
+		if math.IsNaN(p.P) || math.IsNaN(p.Pos.X) || math.IsNaN(p.VDot.X) || math.IsNaN(p.EDot) {
+			t.Fatalf("GradH %v: isolated particle has pressure %v, acceleration %v and EDot %v", gradH, p.P, p.VDot, p.EDot)
+		}
+	}
+}
+
+func TestBallSearch(t *testing.T) {
+	root := MakeCells(clusteredParticles(), Vertical)
+	periodic := [2]float64{0, 1}
+
+	queries := []Vec2{{0.1, 0.5}, {0.7, 0.2}, {0.5, 0.5}, {0.01, 0.99}, {2, 2}}
+	radii := []float64{0, 0.01, 0.05, 0.3}
+
+	var found NNList
+	for _, pos := range queries {
+		for _, r := range radii {
+
+			found = root.BallSearch(pos, r, found.Reset())
+			checkBall(root, pos, r, found, t)
+
+			// every particle once at the distance of its closest image
+			found = root.BallSearchPeriodic(pos, r, periodic, periodic, found.Reset())
+			for k := range found.Index {
+				sep := pos.Sub(&found.Pos[k])
+				if math.Abs(sep.Norm()-found.Dists[k]) > 1e-12 || found.Dists[k] > r {
+					t.Fatalf("periodic search at %v r %v: wrong distance %v", pos, r, found.Dists[k])
+				}
+			}
+
+			count := 0
+			for i := range root.Particles {
+				d := Dist(pos, root.Particles[i].Pos)
+				for _, offset := range []Vec2{{-1, 0}, {1, 0}, {0, -1}, {0, 1}, {-1, -1}, {1, 1}, {-1, 1}, {1, -1}} {
+					d = math.Min(d, Dist(pos.Add(&offset), root.Particles[i].Pos))
+				}
+				if d <= r {
+					count++
+				}
+			}
+			if count != len(found.Index) {
+				t.Fatalf("periodic search at %v r %v: found %v particles, brute force %v", pos, r, len(found.Index), count)
+			}
+		}
+	}
+}
+
+func checkBall(root *Cell, pos Vec2, r float64, found NNList, t *testing.T) {
+	inside := make(map[int32]bool)
+	for i := range root.Particles {
+		if Dist(pos, root.Particles[i].Pos) <= r {
+			inside[int32(i)] = true
+		}
+	}
+
+	if len(inside) != len(found.Index) {
+		t.Fatalf("search at %v r %v: found %v particles, brute force %v", pos, r, len(found.Index), len(inside))
+	}
+	for k, i := range found.Index {
+		if !inside[i] {
+			t.Fatalf("search at %v r %v: particle %v is not inside", pos, r, i)
+		}
+		if found.Pos[k] != root.Particles[i].Pos || math.Abs(found.Dists[k]-Dist(pos, root.Particles[i].Pos)) > 1e-12 {
+			t.Fatalf("search at %v r %v: wrong position or distance of particle %v", pos, r, i)
+		}
+	}
+}
+
+func TestNearestNeighboursOfPoint(t *testing.T) {
+	root := MakeCells(clusteredParticles(), Vertical)
+	periodic := [2]float64{0, 1}
+	open := [2]float64{-math.MaxFloat64, math.MaxFloat64}
+
+	// the last one is exactly on a particle, it has to be found too
+	queries := []Vec2{{0.1, 0.5}, {0.7, 0.2}, {0.01, 0.99}, {3, -2}, root.Particles[42].Pos}
+
+	nn := MakeNNList(NN_SIZE)
+	for _, pos := range queries {
+		for _, boundary := range [][2]float64{open, periodic} {
+			if boundary == open {
+				root.NearestNeighbours(pos, nn)
+			} else {
+				root.NearestNeighboursPeriodic(pos, nn, boundary, boundary)
+			}
+
+			// a point is not a particle, so there is nothing to skip
+			offsets, n := periodicOffsets(boundary, boundary)
+			var dists []float64
+			for j := range root.Particles {
+				for _, offset := range offsets[:n] {
+					dists = append(dists, Dist(pos.Add(&offset), root.Particles[j].Pos))
+				}
+			}
+			slices.Sort(dists)
+
+			for j := range NN_SIZE {
+				k := NN_SIZE - 1 - j
+				if math.Abs(dists[j]-nn.Dists[k]) > 1e-12 {
+					t.Fatalf("point %v: neighbour %v at %v, but brute force gives %v", pos, k, nn.Dists[k], dists[j])
+				}
+				sep := pos.Sub(&nn.Pos[k])
+				if math.Abs(sep.Norm()-nn.Dists[k]) > 1e-12 {
+					t.Fatalf("point %v: neighbour %v is at %v, not at distance %v", pos, k, nn.Pos[k], nn.Dists[k])
+				}
+			}
+		}
+	}
+}
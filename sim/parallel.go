@@ -0,0 +1,63 @@
+package sim
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+const PARALLEL_CHUNK = 256 // particles handed to a worker at once
+
+// Number of goroutines used by the simulation, Config.Workers or all cores
+func (sim *Simulation) NumWorkers() int {
+	if sim.Config.Workers > 0 {
+		return sim.Config.Workers
+	}
+	return runtime.NumCPU()
+}
+
+// Calls f(i) for all i in [0, n) on the worker pool. f must only write to
+// data belonging to i, then the result is the same as the serial loop.
+func (sim *Simulation) parallelFor(n int, f func(i int)) {
+	workers := sim.NumWorkers()
+
+	if workers == 1 || n <= PARALLEL_CHUNK {
+		for i := range n {
+			f(i)
+		}
+		return
+	}
+
+	// workers take the next chunk when they are done, so uneven work is
+	// balanced out
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				start := int(next.Add(PARALLEL_CHUNK)) - PARALLEL_CHUNK
+				if start >= n {
+					return
+				}
+				end := min(start+PARALLEL_CHUNK, n)
+				for i := start; i < end; i++ {
+					f(i)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// parallelFor() over the particle indices in set, all particles for a nil set
+func (sim *Simulation) parallelForSet(set []int, f func(i int)) {
+	if set == nil {
+		sim.parallelFor(len(sim.Root.Particles), f)
+		return
+	}
+	sim.parallelFor(len(set), func(k int) {
+		f(set[k])
+	})
+}
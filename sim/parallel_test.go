@@ -0,0 +1,57 @@
+package sim
+
+import (
+	"testing"
+)
+
+func TestParallelSameAsSerial(t *testing.T) {
+	// the options with their own parallel passes
+	variants := map[string]func(conf *SphConfig){
+		"Default": func(conf *SphConfig) {},
+		"GradH": func(conf *SphConfig) {
+			conf.GradH = true
+		},
+		"ViscositySwitch": func(conf *SphConfig) {
+			conf.Viscosity.Switch = CullenDehnen
+			conf.Viscosity.Balsara = true
+		},
+		"SurfaceTension": func(conf *SphConfig) {
+			conf.GradH = true
+			// small, the gas is not held together by it
+			conf.SurfaceTension = SurfaceTension{Tension: 0.0001}
+		},
+	}
+
+	for name, variant := range variants {
+		conf := MakeConfig()
+		conf.Start = []ParticleSource{UniformRectSpawner{
+			UpperLeft:  Vec2{0.1, 0.1},
+			LowerRight: Vec2{0.6, 0.9},
+			NParticles: 3000,
+		}}
+		conf.HorPeriodicity = [2]float64{0, 1}
+		conf.Gravity.G = 0.001
+		conf.Gravity.Quadrupole = true
+		conf.DeltaTHalf = 0.002
+		variant(&conf)
+
+		conf.Workers = 1
+		serial := MakeSimulationFromConf(conf)
+		conf.Workers = 7
+		parallel := MakeSimulationFromConf(conf)
+
+		for range 5 {
+			serial.Step()
+			parallel.Step()
+		}
+
+		for i := range serial.Root.Particles {
+			if serial.Root.Particles[i] != parallel.Root.Particles[i] {
+				t.Fatalf("%v: particle %v differs:\nserial   %+v\nparallel %+v", name, i, serial.Root.Particles[i], parallel.Root.Particles[i])
+			}
+			if serial.State.Viscous[i] != parallel.State.Viscous[i] || serial.State.Normal[i] != parallel.State.Normal[i] {
+				t.Fatalf("%v: state of particle %v differs", name, i)
+			}
+		}
+	}
+}
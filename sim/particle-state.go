@@ -0,0 +1,93 @@
+/* Per particle state of the optional features
+
+Particle only has the fields every particle needs, the ones the tree walks
+and the density loop go through (Pos, H, Mass, Rho) at its start. Walls,
+species, the viscosity switch and limiter and the surface tension would make
+every particle several times bigger, also with the feature turned off. Their
+state is kept in the arrays of ParticleState instead, indexed like
+Root.Particles and Neighbours.
+
+The arrays are reordered with the particles whenever the tree is updated,
+the IDs tell where every particle was before. A particle that had no ID yet
+gets the default state, so particles are best added with AddParticles().
+*/
+
+package sim
+
+type ParticleState struct {
+	Species []int          // Index into SphConfig.Species, see species.go
+	Wall    []bool         // Fixed boundary particle, see wall.go
+	WallVel []Vec2         // Velocity of wall particles in the physical viscosity, see wall.go
+	Viscous []ViscousState // Viscosity switch and limiter, see viscosity.go
+	Normal  []Vec2         // Surface normal with surface tension, ~0 inside the fluid, see surface-tension.go
+}
+
+// Number of particles with state
+func (state *ParticleState) Len() int {
+	return len(state.Species)
+}
+
+// Appends particles of the species to the simulation, wall particles if
+// wall is set. They get the default mass and their IDs. The tree has to be
+// rebuilt with BuildTree() before the next force calculation.
+func (sim *Simulation) AddParticles(particles []Particle, species int, wall bool) {
+	if sim.Root == nil {
+		sim.Root = &Cell{}
+	}
+	sim.setDefaultMass(particles)
+
+	start := len(sim.Root.Particles)
+	sim.Root.Particles = append(sim.Root.Particles, particles...)
+	sim.assignIDs()
+	sim.resizeState()
+
+	for i := start; i < len(sim.Root.Particles); i++ {
+		sim.State.Species[i] = species
+		sim.State.Wall[i] = wall
+	}
+}
+
+// state of a particle that has none yet
+func (sim *Simulation) defaultViscous() ViscousState {
+	return ViscousState{Alpha: sim.Config.Viscosity.Alpha}
+}
+
+// one entry per particle, the new ones get the default state
+func (sim *Simulation) resizeState() {
+	n := len(sim.Root.Particles)
+	state := &sim.State
+	state.Species = resized(state.Species, n, 0)
+	state.Wall = resized(state.Wall, n, false)
+	state.WallVel = resized(state.WallVel, n, Vec2{})
+	state.Viscous = resized(state.Viscous, n, sim.defaultViscous())
+	state.Normal = resized(state.Normal, n, Vec2{})
+}
+
+// particle i is now the one at from[i], -1 for particles without state
+func (sim *Simulation) reorderState(from []int32) {
+	state := &sim.State
+	state.Species = reordered(state.Species, from, 0)
+	state.Wall = reordered(state.Wall, from, false)
+	state.WallVel = reordered(state.WallVel, from, Vec2{})
+	state.Viscous = reordered(state.Viscous, from, sim.defaultViscous())
+	state.Normal = reordered(state.Normal, from, Vec2{})
+}
+
+func resized[T any](xs []T, n int, def T) []T {
+	for len(xs) < n {
+		xs = append(xs, def)
+	}
+	return xs[:n]
+}
+
+func reordered[T any](xs []T, from []int32, def T) []T {
+	out := make([]T, len(from))
+	for i, j := range from {
+		if j >= 0 && int(j) < len(xs) {
+			out[i] = xs[j]
+		} else {
+			out[i] = def
+		}
+	}
+	return out
+}
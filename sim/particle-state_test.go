@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"testing"
+)
+
+func TestStateFollowsParticles(t *testing.T) {
+	for _, refit := range []bool{false, true} {
+		conf := MakeConfig()
+		conf.Species = []Species{{}, {}}
+		conf.Start = []ParticleSource{
+			UniformRectSpawner{UpperLeft: Vec2{0.1, 0.1}, LowerRight: Vec2{0.5, 0.9}, NParticles: 300},
+			UniformRectSpawner{UpperLeft: Vec2{0.5, 0.1}, LowerRight: Vec2{0.9, 0.9}, NParticles: 300, Species: 1},
+		}
+		conf.Sources = []ParticleSource{&PointSource{origin: Vec2{0.3, 0.3}, rate: 200, Species: 1}}
+		conf.Viscosity.Switch = MorrisMonaghan
+		conf.DeltaTHalf = 0.005
+		conf.TreeRefit = refit
+
+		sim := MakeSimulationFromConf(conf)
+		sim.AddParticles([]Particle{{Pos: Vec2{0.5, 0.95}}}, 0, true)
+		sim.BuildTree()
+
+		// the species by ID, the wall got the last one
+		species := make(map[int]int)
+		for i, p := range sim.Root.Particles {
+			species[p.ID] = sim.State.Species[i]
+		}
+		wall := len(sim.Root.Particles)
+
+		for range 5 {
+			sim.Step()
+			if sim.State.Len() != len(sim.Root.Particles) {
+				t.Fatalf("refit %v: state of %v particles for %v", refit, sim.State.Len(), len(sim.Root.Particles))
+			}
+
+			for i, p := range sim.Root.Particles {
+				want, ok := species[p.ID]
+				if !ok {
+					// spawned by the source
+					want = 1
+				}
+				if sim.State.Species[i] != want {
+					t.Fatalf("refit %v: particle %v has species %v, expected %v", refit, p.ID, sim.State.Species[i], want)
+				}
+				if sim.State.Wall[i] != (p.ID == wall) {
+					t.Fatalf("refit %v: particle %v has wall %v", refit, p.ID, sim.State.Wall[i])
+				}
+			}
+
+			// alpha is carried over by the switch, not reset with the tree
+			s := sim.State.Viscous[sim.IndexOf(1)]
+			if s.Alpha == conf.Viscosity.Alpha {
+				t.Fatalf("refit %v: alpha of particle 1 is still the initial one", refit)
+			}
+		}
+	}
+}
@@ -158,3 +158,33 @@ func TestPartitionEmpty(t *testing.T) {
 	a, b := Partition(psEmpty[:], Horizontal, 0.85)
 	expect(0, 0, len(a), len(b), t)
 }
+
+func TestPartitionSingleVer(t *testing.T) {
+	psSingle := [...]Particle{{Pos: Vec2{0.5, 0.5}}}
+	a, b := Partition(psSingle[:], Vertical, 0.7)
+	expect(1, 0, len(a), len(b), t)
+}
+
+func TestPartitionSingleHor(t *testing.T) {
+	psSingle := [...]Particle{{Pos: Vec2{0.5, 0.5}}}
+	a, b := Partition(psSingle[:], Horizontal, 0.3)
+	expect(0, 1, len(a), len(b), t)
+}
+
+// particles exactly on the middle belong to a
+var psOnMiddle = [...]Particle{
+	{Pos: Vec2{0.4, 0.6}},
+	{Pos: Vec2{0.5, 0.5}},
+	{Pos: Vec2{0.6, 0.4}},
+	{Pos: Vec2{0.5, 0.5}},
+}
+
+func TestPartitionOnMiddleVer(t *testing.T) {
+	a, b := Partition(psOnMiddle[:], Vertical, 0.5)
+	expect(3, 1, len(a), len(b), t)
+}
+
+func TestPartitionOnMiddleHor(t *testing.T) {
+	a, b := Partition(psOnMiddle[:], Horizontal, 0.5)
+	expect(3, 1, len(a), len(b), t)
+}
@@ -0,0 +1,133 @@
+/* Space filling curves for ordering the particles
+
+Before the tree is built the particles can be sorted along a Morton (Z-order)
+or Hilbert curve through the root cell. Particles close in space are then
+close in memory, which helps the cache in the neighbour search and the force
+loops. The Morton order interleaves the bits in the same way the
+AlternatingSplit divides the cells, so Partition() barely has to swap anything
+afterwards. The Hilbert curve has no jumps, but is more expensive to compute.
+*/
+
+package sim
+
+import (
+	"cmp"
+	"slices"
+)
+
+const CURVE_BITS = 21 // bits per axis, 2*21 fit into a uint64 key
+
+// Key of a position along a curve through the box lowerLeft - upperRight
+type CurveKey func(pos, lowerLeft, upperRight Vec2) uint64
+
+// For selecting the ordering by name in the config, "None" keeps the order
+// from the partitioning only.
+var ParticleOrders = map[string]CurveKey{
+	"None":    nil,
+	"Morton":  MortonKey,
+	"Hilbert": HilbertKey,
+}
+
+// Interleaves the bits of the quantized coordinates, y before x, the same
+// way MakeCells() starts with a Vertical split.
+func MortonKey(pos, lowerLeft, upperRight Vec2) uint64 {
+	x, y := quantize(pos, lowerLeft, upperRight)
+	return spreadBits(x) | spreadBits(y)<<1
+}
+
+// Distance along the Hilbert curve, see
+// https://en.wikipedia.org/wiki/Hilbert_curve, 2024
+func HilbertKey(pos, lowerLeft, upperRight Vec2) uint64 {
+	x, y := quantize(pos, lowerLeft, upperRight)
+
+	var d uint64
+	for s := uint64(1) << (CURVE_BITS - 1); s > 0; s /= 2 {
+		var rx, ry uint64
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x&(s-1)
+				y = s - 1 - y&(s-1)
+			}
+			x, y = y, x
+		}
+	}
+	return d
+}
+
+// maps the position to integers in [0, 2^CURVE_BITS)
+func quantize(pos, lowerLeft, upperRight Vec2) (x, y uint64) {
+	const n = 1 << CURVE_BITS
+
+	toInt := func(v, lower, upper float64) uint64 {
+		if upper <= lower || v <= lower {
+			return 0
+		}
+		q := (v - lower) / (upper - lower) * n
+		if q >= n-1 {
+			return n - 1
+		}
+		return uint64(q)
+	}
+
+	return toInt(pos.X, lowerLeft.X, upperRight.X), toInt(pos.Y, lowerLeft.Y, upperRight.Y)
+}
+
+// puts a zero bit between all the lower CURVE_BITS bits of x
+func spreadBits(x uint64) uint64 {
+	x &= 0x1fffff
+	x = (x | x<<32) & 0x1f00000000ffff
+	x = (x | x<<16) & 0x1f0000ff0000ff
+	x = (x | x<<8) & 0x100f00f00f00f00f
+	x = (x | x<<4) & 0x10c30c30c30c30c3
+	x = (x | x<<2) & 0x1249249249249249
+	return x
+}
+
+// Sorts the particles in place along the curve given by key through the box
+// lowerLeft - upperRight.
+func SortParticles(particles []Particle, key CurveKey, lowerLeft, upperRight Vec2) {
+
+	type entry struct {
+		key   uint64
+		index int
+	}
+
+	order := make([]entry, len(particles))
+	for i := range particles {
+		order[i] = entry{key(particles[i].Pos, lowerLeft, upperRight), i}
+	}
+
+	slices.SortFunc(order, func(a, b entry) int {
+		return cmp.Compare(a.key, b.key)
+	})
+
+	// apply the permutation in place by following its cycles, particles
+	// are big so we don't want to copy all of them into a second array
+	for start := range order {
+		if order[start].index == start || order[start].index < 0 {
+			continue
+		}
+
+		tmp := particles[start]
+		i := start
+		for {
+			from := order[i].index
+			order[i].index = -1
+			if from == start {
+				particles[i] = tmp
+				break
+			}
+			particles[i] = particles[from]
+			i = from
+		}
+	}
+}
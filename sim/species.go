@@ -0,0 +1,79 @@
+/* Particle species
+
+Every particle has a species, an index into SphConfig.Species kept in
+ParticleState, so different fluids
+can be simulated together, e.g. oil on water or Rayleigh-Taylor. A species
+has its own equation of state, physical viscosity and colour ramp. The mass
+is set per particle by the spawners, the species doesn't know it.
+
+Without species all particles use EOS and Viscosity.Mu of the config. With
+species the Mu of the species replaces the one of the config, its EOS only if
+it is set.
+*/
+
+package sim
+
+import (
+	"github.com/bbeni/sphugo/gx"
+)
+
+type Species struct {
+	EOS  EOS                  // nil uses SphConfig.EOS
+	Mu   float64              // Dynamic viscosity of the physical term
+	Ramp func(uint8) gx.Color // Colour of the particles by density, nil is gx.ParaRamp
+}
+
+// Colour ramps by their name in the config file
+var ColorRamps = map[string]func(uint8) gx.Color{
+	"Para":    gx.ParaRamp,
+	"Heat":    gx.HeatRamp,
+	"Toxic":   gx.ToxicRamp,
+	"Rainbow": gx.RainbowRamp,
+}
+
+func (conf *SphConfig) speciesOf(species int) *Species {
+	if species < 0 || species >= len(conf.Species) {
+		return nil
+	}
+	return &conf.Species[species]
+}
+
+// Equation of state of the particles of the species
+func (conf *SphConfig) EOSOf(species int) EOS {
+	if s := conf.speciesOf(species); s != nil && s.EOS != nil {
+		return s.EOS
+	}
+	return conf.EOS
+}
+
+// Dynamic viscosity of the particles of the species
+func (conf *SphConfig) MuOf(species int) float64 {
+	if len(conf.Species) == 0 {
+		return conf.Viscosity.Mu
+	}
+	if s := conf.speciesOf(species); s != nil {
+		return s.Mu
+	}
+	return 0
+}
+
+// Colour ramp of the particles of the species
+func (conf *SphConfig) RampOf(species int) func(uint8) gx.Color {
+	if s := conf.speciesOf(species); s != nil && s.Ramp != nil {
+		return s.Ramp
+	}
+	return gx.ParaRamp
+}
+
+// True if any particle can have a physical viscosity
+func (conf *SphConfig) PhysicalViscosity() bool {
+	if len(conf.Species) == 0 {
+		return conf.Viscosity.Mu > 0
+	}
+	for i := range conf.Species {
+		if conf.Species[i].Mu > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package sim
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpeciesConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "species.conf")
+	source := `[[Start]]
+[UniformRect]
+NParticles 10
+UpperLeft 0 0
+LowerRight 1 1
+Mass 2
+Species 1
+[UniformRect]
+NParticles 20
+UpperLeft 0 0
+LowerRight 1 1
+[[Species]]
+[Fluid]
+EOS Tait
+RestDensity 1000
+[Fluid]
+Mu 0.5
+Color Heat
+`
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := MakeConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("could not parse species config: %v", err)
+	}
+	if len(conf.Species) != 2 {
+		t.Fatalf("expected 2 species, got %v", len(conf.Species))
+	}
+	if conf.Species[0].EOS != (Tait{Rho0: 1000, C0: 10, Gamma: 7}) || conf.Species[1].Mu != 0.5 || conf.Species[1].Ramp == nil {
+		t.Fatalf("species not applied: %+v", conf.Species)
+	}
+
+	sim := MakeSimulationFromConf(conf)
+	count := [2]int{}
+	for i, p := range sim.Root.Particles {
+		species := sim.State.Species[i]
+		count[species]++
+		if species == 1 && p.Mass != 2 || species == 0 && p.Mass != conf.ParticleMass {
+			t.Fatalf("particle of species %v has mass %v", species, p.Mass)
+		}
+	}
+	if count != [2]int{20, 10} {
+		t.Fatalf("expected 20 and 10 particles of the species, got %v", count)
+	}
+
+	// there is no species 2
+	source = "[[Start]]\n[UniformRect]\nNParticles 10\nUpperLeft 0 0\nLowerRight 1 1\nSpecies 2\n[[Species]]\n[Fluid]\n[Fluid]\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := MakeConfigFromFile(path); err == nil {
+		t.Fatalf("undefined species should not be accepted")
+	}
+}
+
+func TestSpeciesEOS(t *testing.T) {
+	conf := MakeConfig()
+	conf.Species = []Species{{}, {EOS: Isothermal{C: 3}, Mu: 0.1}}
+	conf.Start = []ParticleSource{
+		UniformRectSpawner{LowerRight: Vec2{1, 0.5}, NParticles: 300},
+		UniformRectSpawner{UpperLeft: Vec2{0, 0.5}, LowerRight: Vec2{1, 1}, NParticles: 300, Mass: 3, Species: 1},
+	}
+
+	sim := MakeSimulationFromConf(conf)
+	sim.CalculateForces()
+
+	for i, p := range sim.Root.Particles {
+		species := sim.State.Species[i]
+		var want float64
+		if species == 0 {
+			want = conf.EOS.Pressure(p.Rho, p.EPred)
+		} else {
+			want = 9 * p.Rho
+		}
+		if p.P != want {
+			t.Fatalf("particle of species %v has pressure %v, expected %v", species, p.P, want)
+		}
+		if mu := sim.Config.MuOf(species); mu != 0.1*float64(species) {
+			t.Fatalf("particle of species %v has mu %v", species, mu)
+		}
+	}
+	if !sim.Config.PhysicalViscosity() {
+		t.Fatalf("species 1 has a physical viscosity")
+	}
+}
@@ -16,10 +16,24 @@ type Simulation struct {
 
 	Root        *Cell // Tree structure for keeping track of spatial cells of particles
 	CurrentStep int
+	Time        float64    // Simulated time
+	TimeSteps   []TimeStep // Time and dt of every step done
+
+	Neighbours Neighbours    // Nearest neighbours of Root.Particles, see FindNearestNeighbours()
+	State      ParticleState // Walls, species, viscosity switch, ... of Root.Particles, see particle-state.go
+
+	nextID    int     // ID given to the next spawned particle
+	idToIndex []int32 // Current index in Root.Particles of each ID, -1 if unknown
 
 	IsBusy sync.Mutex
 }
 
+type TimeStep struct {
+	Time     float64 // Simulated time after the step
+	DeltaT   float64
+	Substeps int // Force calculations in the step, more than 1 only with time bins
+}
+
 func MakeSimulation() Simulation {
 	sim := Simulation{
 		Config: MakeConfig(),
@@ -43,13 +57,14 @@ func MakeSimulationFromConf(conf SphConfig) Simulation {
 		Config: conf,
 	}
 
-	ps := make([]Particle, 0, 100000)
+	sim.Root = &Cell{Particles: make([]Particle, 0, 100000)}
 
 	for _, startSpawner := range sim.Config.Start {
-		ps = append(ps, startSpawner.Spawn(0)...)
+		sim.AddParticles(startSpawner.Spawn(0), spawnerSpecies(startSpawner), false)
 	}
+	sim.AddParticles(sim.Config.WallParticles(), 0, true)
 
-	sim.Root = MakeCells(ps, Vertical)
+	sim.BuildTree()
 	return sim
 }
 
@@ -65,22 +80,23 @@ func (sim *Simulation) Step() {
 
 	sim.IsBusy.Lock()
 
-	// constants
-	dtHalf := sim.Config.DeltaTHalf
-
 	// sources spawn particles
 	{
-		t := float64(sim.CurrentStep) * dtHalf * 2
-
-		i := -1
-		for i = range sim.Config.Sources {
+		spawned := false
+		for i := range sim.Config.Sources {
 			spwn := &sim.Config.Sources[i]
-			newParticles := (*spwn).Spawn(t)
-			sim.Root.Particles = append(sim.Root.Particles, newParticles...)
+			newParticles := (*spwn).Spawn(sim.Time)
+			for j := range newParticles {
+				newParticles[j].VPred = newParticles[j].Vel
+				newParticles[j].EPred = newParticles[j].E
+				newParticles[j].Bin = sim.MaxTimeBin()
+			}
+			sim.AddParticles(newParticles, spawnerSpecies(*spwn), false)
+			spawned = spawned || len(newParticles) > 0
 		}
 
-		if i != -1 {
-			sim.Root = MakeCells(sim.Root.Particles, Vertical)
+		if spawned {
+			sim.BuildTree()
 		}
 
 	}
@@ -93,126 +109,260 @@ func (sim *Simulation) Step() {
 			panic("int Run(): Simulation not initialized!")
 		}
 
+		// the tree might have been set up by hand, e.g. with MakeCellWith()
+		sim.setDefaultMass(sim.Root.Particles)
+		sim.assignIDs()
+		sim.resizeState()
+
 		// initialization drift dt=0
 		for i, p := range sim.Root.Particles {
 			sim.Root.Particles[i].VPred = p.Vel
 			sim.Root.Particles[i].EPred = p.E
+			sim.State.Viscous[i] = sim.defaultViscous()
 		}
 
 		sim.CalculateForces()
+
+		if sim.Config.HierarchicalTimeStep {
+			sim.assignTimeBins(0)
+		}
 	}
 
-	// real work done here
-	{
-		// drift 1 for leapfrog dt/2
-		for i, _ := range sim.Root.Particles {
-			p := &sim.Root.Particles[i]
+	if sim.Config.HierarchicalTimeStep {
+		sim.stepTimeBins()
+		sim.CurrentStep += 1
+		sim.IsBusy.Unlock()
+		return
+	}
+
+	dtHalf := sim.Config.DeltaTHalf
+	if sim.Config.AdaptiveTimeStep {
+		dtHalf = sim.AdaptiveDeltaTHalf()
+	}
+
+	sim.Config.Integrator.Step(sim, 2*dtHalf)
+	sim.applyBoundaries()
+
+	for i := range sim.Root.Particles {
+		sim.Config.Viscosity.UpdateAlpha(&sim.Root.Particles[i], &sim.State.Viscous[i], 2*dtHalf)
+	}
+
+	sim.CurrentStep += 1
+	sim.Time += 2 * dtHalf
+	sim.TimeSteps = append(sim.TimeSteps, TimeStep{Time: sim.Time, DeltaT: 2 * dtHalf, Substeps: 1})
+	sim.IsBusy.Unlock()
+}
 
-			vdt := p.Vel.Mul(dtHalf)
-			p.Pos = p.Pos.Add(&vdt)
+// Wraps around the periodic boundaries and mirrors at the reflections
+func (sim *Simulation) applyBoundaries() {
+	// Boundary: particles outside boundary get moved around
+	//  x1              x2
+	// x
+	// x ->   x2-x1 + x
+	//
+	//  x1              x2
+	//                     x
+	// x -> -(x1-x2) + x
+	//
 
-			adt := p.VDot.Mul(dtHalf)
-			p.VPred = p.Vel.Add(&adt)
-			p.EPred = p.E + p.EDot*dtHalf
+	for i, _ := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		if p.Pos.X < sim.Config.HorPeriodicity[0] {
+			p.Pos.X += (sim.Config.HorPeriodicity[1] - sim.Config.HorPeriodicity[0])
+			continue
 		}
 
-		sim.CalculateForces()
+		if p.Pos.X > sim.Config.HorPeriodicity[1] {
+			p.Pos.X -= (sim.Config.HorPeriodicity[1] - sim.Config.HorPeriodicity[0])
+			continue
+		}
 
-		// kick dt
-		for i, _ := range sim.Root.Particles {
-			p := &sim.Root.Particles[i]
-			adt := p.VDot.Mul(2 * dtHalf)
-			p.Vel = p.Vel.Add(&adt)
-			p.E = p.E + p.EDot*2*dtHalf
+		if p.Pos.Y < sim.Config.VertPeriodicity[0] {
+			p.Pos.Y += (sim.Config.VertPeriodicity[1] - sim.Config.VertPeriodicity[0])
+			continue
 		}
 
-		// drift 2 for leapfrog dt/2
-		for i, _ := range sim.Root.Particles {
-			p := &sim.Root.Particles[i]
+		if p.Pos.Y > sim.Config.VertPeriodicity[1] {
+			p.Pos.Y -= (sim.Config.VertPeriodicity[1] - sim.Config.VertPeriodicity[0])
+		}
+	}
 
-			vdt := p.Vel.Mul(dtHalf)
-			p.Pos = p.Pos.Add(&vdt)
+	// TODO: unhardcode refelction boundaries
+	for i, _ := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		if sim.State.Wall[i] {
+			continue
 		}
 
-		// Boundary: particles outside boundary get moved around
-		//  x1              x2
-		// x
-		// x ->   x2-x1 + x
-		//
-		//  x1              x2
-		//                     x
-		// x -> -(x1-x2) + x
-		//
+		// Left reflection
+		if p.Pos.X < sim.Config.Reflections.L {
+			p.Pos.X -= p.Pos.X - sim.Config.Reflections.L
+			p.Vel.X = -p.Vel.X
+			p.VPred.X = -p.VPred.X
+		}
+		// Right reflection
+		if p.Pos.X > sim.Config.Reflections.R {
+			p.Pos.X -= p.Pos.X - sim.Config.Reflections.R
+			p.Vel.X = -p.Vel.X
+			p.VPred.X = -p.VPred.X
+		}
+		// Up reflection
+		if p.Pos.Y < sim.Config.Reflections.U {
+			p.Pos.Y -= p.Pos.Y - sim.Config.Reflections.U
+			p.Vel.Y = -p.Vel.Y
+			p.VPred.Y = -p.VPred.Y
+		}
+		// Down reflection
+		if p.Pos.Y > sim.Config.Reflections.D {
+			p.Pos.Y -= p.Pos.Y - sim.Config.Reflections.D
+			p.Vel.Y = -p.Vel.Y
+			p.VPred.Y = -p.VPred.Y
+		}
+	}
+}
 
-		for i, _ := range sim.Root.Particles {
-			p := &sim.Root.Particles[i]
-			if p.Pos.X < sim.Config.HorPeriodicity[0] {
-				p.Pos.X += (sim.Config.HorPeriodicity[1] - sim.Config.HorPeriodicity[0])
-				continue
-			}
+// Half of the time step allowed by the particles with the forces of the last
+// CalculateForces(), at most Config.DeltaTHalf. See ParticleDeltaT().
+func (sim *Simulation) AdaptiveDeltaTHalf() float64 {
+	dt := 2 * sim.Config.DeltaTHalf
+	for i := range sim.Root.Particles {
+		dt = math.Min(dt, sim.ParticleDeltaT(i))
+	}
+	return dt / 2
+}
 
-			if p.Pos.X > sim.Config.HorPeriodicity[1] {
-				p.Pos.X -= (sim.Config.HorPeriodicity[1] - sim.Config.HorPeriodicity[0])
-				continue
-			}
+// Time step the particle allows with its last forces, +Inf if it doesn't care:
+//
+//	dt = CourantFactor * h / VSig   (VSig contains c, |v| and the viscosity)
+//	dt = ForceFactor * sqrt(h / |a|)
+func (sim *Simulation) ParticleDeltaT(i int) float64 {
+	p := &sim.Root.Particles[i]
+	dt := math.Inf(1)
+
+	if p.VSig > 0 {
+		dt = math.Min(dt, sim.Config.CourantFactor*p.H/p.VSig)
+	}
 
-			if p.Pos.Y < sim.Config.VertPeriodicity[0] {
-				p.Pos.Y += (sim.Config.VertPeriodicity[1] - sim.Config.VertPeriodicity[0])
-				continue
-			}
+	a := p.VDot.Norm()
+	if a > 0 {
+		dt = math.Min(dt, sim.Config.ForceFactor*math.Sqrt(p.H/a))
+	}
+
+	// viscous diffusion, 0.125 h^2 / nu with h ~ H/2 (Morris 1997)
+	if mu := sim.Config.MuOf(sim.State.Species[i]); mu > 0 {
+		dt = math.Min(dt, 0.125*0.25*p.H*p.H*p.Rho/mu)
+	}
+
+	return dt
+}
+
+// particles without a mass get the one of the config
+func (sim *Simulation) setDefaultMass(particles []Particle) {
+	for i := range particles {
+		if particles[i].Mass == 0 {
+			particles[i].Mass = sim.Config.ParticleMass
+		}
+	}
+}
 
-			if p.Pos.Y > sim.Config.VertPeriodicity[1] {
-				p.Pos.Y -= (sim.Config.VertPeriodicity[1] - sim.Config.VertPeriodicity[0])
+// particles without an ID get the next free one, at the index they have now
+func (sim *Simulation) assignIDs() {
+	if sim.nextID == 0 {
+		sim.nextID = 1
+	}
+	particles := sim.Root.Particles
+	for i := range particles {
+		if particles[i].ID == 0 {
+			particles[i].ID = sim.nextID
+			sim.nextID += 1
+			for len(sim.idToIndex) < sim.nextID {
+				sim.idToIndex = append(sim.idToIndex, -1)
 			}
+			sim.idToIndex[particles[i].ID] = int32(i)
 		}
+	}
+}
 
-		// TODO: unhardcode refelction boundaries
-		for i, _ := range sim.Root.Particles {
-			p := &sim.Root.Particles[i]
+// Rebuilds the lookup from ID to index after the particles were reordered,
+// the ParticleState is reordered with them
+func (sim *Simulation) updateIDIndex() {
+	from := make([]int32, len(sim.Root.Particles))
+	moved := len(sim.Root.Particles) != sim.State.Len()
+	for i := range sim.Root.Particles {
+		from[i] = int32(sim.IndexOf(sim.Root.Particles[i].ID))
+		moved = moved || from[i] != int32(i)
+	}
+	if moved {
+		sim.reorderState(from)
+	}
 
-			// Left reflection
-			if p.Pos.X < sim.Config.Reflections.L {
-				p.Pos.X -= p.Pos.X - sim.Config.Reflections.L
-				p.Vel.X = -p.Vel.X
-			}
-			// Right reflection
-			if p.Pos.X > sim.Config.Reflections.R {
-				p.Pos.X -= p.Pos.X - sim.Config.Reflections.R
-				p.Vel.X = -p.Vel.X
-			}
-			// Up reflection
-			if p.Pos.Y < sim.Config.Reflections.U {
-				p.Pos.Y -= p.Pos.Y - sim.Config.Reflections.U
-				p.Vel.Y = -p.Vel.Y
-			}
-			// Down reflection
-			if p.Pos.Y > sim.Config.Reflections.D {
-				p.Pos.Y -= p.Pos.Y - sim.Config.Reflections.D
-				p.Vel.Y = -p.Vel.Y
-			}
+	if cap(sim.idToIndex) < sim.nextID {
+		sim.idToIndex = make([]int32, sim.nextID, 2*sim.nextID)
+	}
+	sim.idToIndex = sim.idToIndex[:sim.nextID]
+
+	for i := range sim.idToIndex {
+		sim.idToIndex[i] = -1
+	}
+	for i := range sim.Root.Particles {
+		id := sim.Root.Particles[i].ID
+		if id > 0 && id < len(sim.idToIndex) {
+			sim.idToIndex[id] = int32(i)
 		}
 	}
+}
 
-	sim.CurrentStep += 1
-	sim.IsBusy.Unlock()
+// Current index in Root.Particles of the particle with this ID, -1 if there
+// is no such particle. Indices change whenever the tree is updated.
+func (sim *Simulation) IndexOf(id int) int {
+	if id <= 0 || id >= len(sim.idToIndex) {
+		return -1
+	}
+	return int(sim.idToIndex[id])
+}
+
+// The particle with this ID or nil
+func (sim *Simulation) ParticleByID(id int) *Particle {
+	i := sim.IndexOf(id)
+	if i < 0 {
+		return nil
+	}
+	return &sim.Root.Particles[i]
+}
+
+// Appends the IDs of the nearest neighbours of the particle with this ID to
+// ids. The neighbours are the ones of the last force calculation.
+func (sim *Simulation) NeighbourIDs(id int, ids []int) []int {
+	i := sim.IndexOf(id)
+	if i < 0 || sim.Neighbours.Len() != len(sim.Root.Particles) {
+		return ids
+	}
+
+	nn := sim.Neighbours.Of(i)
+	for _, j := range nn.Index {
+		if j >= 0 {
+			ids = append(ids, sim.Root.Particles[j].ID)
+		}
+	}
+	return ids
 }
 
 // lets assume mass 1 per particle, so the density is just the 1/volume of sphere
-func DensityTopHat3D(p *Particle) float64 {
-	maxR := p.NNDists[0]
-	return 3 * NN_SIZE / (4 * math.Pi * maxR * maxR * maxR)
+func DensityTopHat3D(nn NNList) float64 {
+	maxR := nn.Dists[0]
+	return 3 * float64(len(nn.Dists)) / (4 * math.Pi * maxR * maxR * maxR)
 }
 
 // lets assume mass 1 per particle, so the density is just the 1/volume of sphere
-func DensityMonahan3D(p *Particle) float64 {
-	maxR := p.NNDists[0]
+func DensityMonahan3D(nn NNList) float64 {
+	maxR := nn.Dists[0]
 
 	acc := 0.0
 	var x float64
 
 	var i int
-	for i = range NN_SIZE {
-		x = p.NNDists[i] / maxR
+	for i = range nn.Dists {
+		x = nn.Dists[i] / maxR
 
 		if x > 1 || x < 0 {
 			panic("unreachable")
@@ -229,107 +379,59 @@ func DensityMonahan3D(p *Particle) float64 {
 }
 
 // lets assume mass 1 per particle, so the density is just the 1/volume of sphere
-func DensityTopHat2D(p *Particle) float64 {
-	maxR := p.NNDists[0]
-	return NN_SIZE / (math.Pi * maxR * maxR)
+func DensityTopHat2D(nn NNList) float64 {
+	maxR := nn.Dists[0]
+	return float64(len(nn.Dists)) / (math.Pi * maxR * maxR)
 }
 
-type Kernel struct {
-	F           func(q float64) float64
-	FPrefactor  float64
-	DF          func(q float64) float64
-	DFPrefactor float64
-}
-
-var TopHat2D = Kernel{
-	F: func(q float64) float64 {
-		return 1
-	},
-
-	FPrefactor: 1 / math.Pi,
-
-	DF: func(q float64) float64 {
-		panic("not defined. derivative is delta distribution!")
-	},
-
-	DFPrefactor: 1,
-}
+// Density of particle i from its neighbours in sim.Neighbours
+func Density2D(i int, sim *Simulation, kernel Kernel) float64 {
+	nn := sim.Neighbours.Of(i)
+	particles := sim.Root.Particles
+	maxR := nn.Farthest()
 
-var Monahan2D = Kernel{
-	F: func(q float64) float64 {
-		if q < 0.5 {
-			return q*q*q - q*q + 1.0/6
-		}
-		return (1 - q) * (1 - q) * (1 - q) / 3
-	},
-
-	FPrefactor: 6 * 40 / (math.Pi * 7),
-
-	DF: func(q float64) float64 {
-		if q < 0.5 {
-			return (3*q*q - 2*q)
-		}
-		return -(1 - q) * (1 - q)
-	},
-
-	DFPrefactor: 6 * 40 / (math.Pi * 7),
-}
-
-// change varibales from lecture to adapt for factor of 2 in h
-// q -> 2*q
-// 1/h -> 2/rmax
-// 2d F  prefactor -> 4 * ..
-// 2d DF prefactor -> 8 * ..
-
-var Wendtland2D = Kernel{
-	F: func(q float64) float64 {
-		if q <= 1 {
-			return (1 - q) * (1 - q) * (1 - q) * (1 - q) * (1 + 4*q)
-		} else {
-			panic("not good..")
-		}
-	},
-
-	FPrefactor: 4 * 7 / (math.Pi * 4),
-
-	DF: func(q float64) float64 {
-		if q <= 1 {
-			return -10 * q * (1 - q) * (1 - q) * (1 - q)
-		} else {
-			panic("not good..")
-		}
-	},
-
-	DFPrefactor: 8 * 7 / (math.Pi * 4),
-}
-
-func Density2D(p *Particle, sim *Simulation, kernel Kernel) float64 {
-	maxR := p.NNDists[0]
+	// isolated, it only sees itself
+	if math.IsInf(maxR, 1) {
+		p := &particles[i]
+		return p.Mass * kernelW(kernel, 0, p.H)
+	}
 
 	acc := 0.0
 	var x float64
 
-	var i int
-	for i = range NN_SIZE {
-		x = p.NNDists[i] / maxR
+	for j := range nn.Dists {
+		// less particles than NNSize in the whole simulation
+		if nn.Index[j] < 0 {
+			continue
+		}
+
+		x = nn.Dists[j] / maxR
 
 		if x > 1 || x < 0 {
 			panic("unreachable")
 		}
-		acc += kernel.F(x)
+		acc += particles[nn.Index[j]].Mass * kernel.F(x)
 	}
 
-	return kernel.FPrefactor * sim.Config.ParticleMass * acc / (maxR * maxR)
+	return kernel.FPrefactor * acc / (maxR * maxR)
 }
 
 //   - Sum [ (Pa/rhoa^2       + Pb/rhob^2     + PIab )]
 //     contribution A  + contributionB
-func AccelerationAndEDot2D(p *Particle, sim *Simulation, kernel Kernel) {
-	gamma := sim.Config.Gamma
-	maxR := p.NNDists[0]
+//
+// Also sets the signal speed VSig for the time step.
+func AccelerationAndEDot2D(i int, sim *Simulation, kernel Kernel) {
+	p := &sim.Root.Particles[i]
+	nns := sim.Neighbours.Of(i)
+	visc := &sim.Config.Viscosity
+	st := &sim.Config.SurfaceTension
+	physical := sim.Config.PhysicalViscosity()
+	muA := sim.Config.MuOf(sim.State.Species[i])
+	viscA := &sim.State.Viscous[i]
+	maxR := nns.Farthest()
 
 	// PA / rhoA^2
-	contributionA := p.C * p.C / (gamma * p.Rho)
+	contributionA := p.P / (p.Rho * p.Rho)
 	contributionB := 0.0
 
 	dRKernel := 0.0
@@ -338,17 +440,24 @@ func AccelerationAndEDot2D(p *Particle, sim *Simulation, kernel Kernel) {
 	acc_ay := 0.0
 	acc_edot := 0.0
 
+	// physical viscosity and surface tension, see viscosity.go and surface-tension.go
+	accMorris := Vec2{}
+	edotMorris := 0.0
+
+	// strongest approach of a neighbour for the signal speed
+	muMax := 0.0
+
 	var q float64
-	var i int
-	for i = range NN_SIZE {
-		nn := p.NearestNeighbours[i]
+	for j := range nns.Dists {
 
-		// TODO: stupid fix because NN are nil sometimes...
-		if nn == nil {
-			break
+		// less particles than NNSize in the whole simulation
+		if nns.Index[j] < 0 {
+			continue
 		}
+		b := int(nns.Index[j])
+		nn := &sim.Root.Particles[b]
 
-		q = p.NNDists[i] / maxR // r/h in lecture
+		q = nns.Dists[j] / maxR // r/h in lecture
 
 		if q > 1 || q < 0 {
 			panic("kernel parameter q not in [0, 1]!")
@@ -357,7 +466,7 @@ func AccelerationAndEDot2D(p *Particle, sim *Simulation, kernel Kernel) {
 		dRKernel = kernel.DF(q)
 
 		// PB / rhoB^2
-		contributionB = nn.C * nn.C / (gamma * nn.Rho)
+		contributionB = nn.P / (nn.Rho * nn.Rho)
 
 		vA := p.VPred
 		vB := nn.VPred
@@ -365,7 +474,7 @@ func AccelerationAndEDot2D(p *Particle, sim *Simulation, kernel Kernel) {
 		//vB := nn.Vel
 
 		rA := p.Pos
-		rB := p.NNPos[i]
+		rB := nns.Pos[j]
 
 		//
 		// Viscosity Term
@@ -374,64 +483,265 @@ func AccelerationAndEDot2D(p *Particle, sim *Simulation, kernel Kernel) {
 		vAB := vB.Sub(&vA)
 		rAB := rB.Sub(&rA)
 		dot := vAB.Dot(&rAB)
-		piAB := 0.0
-		if dot < 0 {
-			const (
-				alpha = 0.75
-				beta  = 1.5
-				etaSq = 0.01
-			)
-			cAB := 0.5 * (p.C + nn.C)
-			rhoAB := 0.5 * (p.Rho + nn.Rho)
-			hAB := 0.5 * (p.NNDists[0] + nn.NNDists[0])
-			muAB := dot * hAB / (rAB.Dot(&rAB) + etaSq)
-			piAB = (-alpha*cAB*muAB + beta*muAB*muAB) / rhoAB
+		piAB, muAB := visc.Pi(p, nn, viscA, &sim.State.Viscous[b], rAB, vAB)
+		muMax = math.Max(muMax, -muAB)
+
+		acc_ax += nn.Mass * rAB.X * (piAB + contributionA + contributionB) * dRKernel / nns.Dists[j]
+		acc_ay += nn.Mass * rAB.Y * (piAB + contributionA + contributionB) * dRKernel / nns.Dists[j]
+		acc_edot += nn.Mass * dot * dRKernel
+
+		if physical {
+			dW := kernel.DFPrefactor * dRKernel / (maxR * maxR * maxR)
+			nuAB := nn.Mass * Morris(p, nn, muA, sim.Config.MuOf(sim.State.Species[b]), nns.Dists[j], dW, maxR)
+			vVisc := sim.viscousVelocity(b)
+			vVisc = vVisc.Sub(&p.VPred)
+			dv := vVisc.Mul(nuAB)
+			accMorris = accMorris.Add(&dv)
+			edotMorris += 0.5 * nuAB * vVisc.Dot(&vVisc)
 		}
+	}
 
-		acc_ax += rAB.X * (piAB + contributionA + contributionB) * dRKernel / p.NNDists[i]
-		acc_ay += rAB.Y * (piAB + contributionA + contributionB) * dRKernel / p.NNDists[i]
-		acc_edot += dot * dRKernel
+	// the nearest neighbours are not symmetric, the pairs are
+	if st.Tension > 0 {
+		dv := SurfaceTension2D(i, sim)
+		accMorris = accMorris.Add(&dv)
 	}
 
 	acc := Vec2{acc_ax, acc_ay}
-	acc = acc.Mul(sim.Config.ParticleMass * kernel.DFPrefactor / (maxR * maxR * maxR))
+	acc = acc.Mul(kernel.DFPrefactor / (maxR * maxR * maxR))
 	acc = acc.Add(&sim.Config.Acceleration)
+	acc = acc.Add(&accMorris)
 	p.VDot = acc
-	p.EDot = contributionA * acc_edot * sim.Config.ParticleMass // Benz formulation
+	p.EDot = contributionA*acc_edot + edotMorris // Benz formulation
+
+	p.VSig = visc.SignalSpeed(p, viscA, muMax)
 }
 
-func (sim *Simulation) CalculateForces() {
+// Box spanned by the finite periodic and reflection limits of the config.
+// Open sides are left at -math.MaxFloat64 or math.MaxFloat64.
+func (sim *Simulation) Domain() (lowerLeft, upperRight Vec2) {
+	conf := &sim.Config
 
-	// rebuild the tree to perserve data locality
-	sim.Root.Treebuild(Vertical)
+	lowerLeft = Vec2{math.MaxFloat64, math.MaxFloat64}
+	upperRight = Vec2{-math.MaxFloat64, -math.MaxFloat64}
 
-	sim.Root.BoundingSpheres()
+	if conf.HorPeriodicity[0] != -math.MaxFloat64 {
+		lowerLeft.X = conf.HorPeriodicity[0]
+		upperRight.X = conf.HorPeriodicity[1]
+	}
+	if conf.VertPeriodicity[0] != -math.MaxFloat64 {
+		lowerLeft.Y = conf.VertPeriodicity[0]
+		upperRight.Y = conf.VertPeriodicity[1]
+	}
 
-	// claculate all nearest neighbours
-	for i, _ := range sim.Root.Particles {
-		sim.Root.Particles[i].FindNearestNeighboursPeriodic(sim.Root, sim.Config.HorPeriodicity, sim.Config.VertPeriodicity)
+	if conf.Reflections.L != -math.MaxFloat64 {
+		lowerLeft.X = math.Min(lowerLeft.X, conf.Reflections.L)
+	}
+	if conf.Reflections.R != math.MaxFloat64 {
+		upperRight.X = math.Max(upperRight.X, conf.Reflections.R)
+	}
+	if conf.Reflections.U != -math.MaxFloat64 {
+		lowerLeft.Y = math.Min(lowerLeft.Y, conf.Reflections.U)
+	}
+	if conf.Reflections.D != math.MaxFloat64 {
+		upperRight.Y = math.Max(upperRight.Y, conf.Reflections.D)
 	}
 
-	// Calculate Nearest Neighbor Density Rho
-	for i, _ := range sim.Root.Particles {
+	return lowerLeft, upperRight
+}
+
+// Builds a new tree over all particles. The root cell spans the
+// configured domain and all particles, even if they left it.
+func (sim *Simulation) BuildTree() {
+	lowerLeft, upperRight := sim.Domain()
+
+	// leave some room for the particles to move before a refit fails
+	if sim.Config.TreeRefit {
+		bLowerLeft, bUpperRight := ParticleBounds(sim.Root.Particles)
+		margin := bUpperRight.Sub(&bLowerLeft).Mul(0.05)
+		bLowerLeft = bLowerLeft.Sub(&margin)
+		bUpperRight = bUpperRight.Add(&margin)
+		lowerLeft = Vec2{math.Min(lowerLeft.X, bLowerLeft.X), math.Min(lowerLeft.Y, bLowerLeft.Y)}
+		upperRight = Vec2{math.Max(upperRight.X, bUpperRight.X), math.Max(upperRight.Y, bUpperRight.Y)}
+	}
+
+	if sim.Config.ParticleOrder != nil {
+		// sort in the same box the root cell will get, so that the curve
+		// follows the cell splits
+		bLowerLeft, bUpperRight := ParticleBounds(sim.Root.Particles)
+		lowerLeft = Vec2{math.Min(lowerLeft.X, bLowerLeft.X), math.Min(lowerLeft.Y, bLowerLeft.Y)}
+		upperRight = Vec2{math.Max(upperRight.X, bUpperRight.X), math.Max(upperRight.Y, bUpperRight.Y)}
+		SortParticles(sim.Root.Particles, sim.Config.ParticleOrder, lowerLeft, upperRight)
+	}
+
+	sim.Root = MakeCellsInBox(sim.Root.Particles, Vertical, sim.Config.TreeSplit, lowerLeft, upperRight)
+	sim.updateIDIndex()
+}
+
+// Brings the tree up to date with the moved particles. Depending on the
+// config the tree is refitted or rebuilt. A refitted tree is rebuilt anyway
+// if particles left it or the particles of a cell grew or shrank by more than
+// RefitImbalance since it was built, see Cell.Imbalance().
+func (sim *Simulation) UpdateTree() {
+	if !sim.Config.TreeRefit || !sim.Root.Refit(sim.Config.TreeSplit) {
+		sim.BuildTree()
+		return
+	}
+
+	if sim.Root.Imbalance() > sim.Config.RefitImbalance {
+		sim.BuildTree()
+		return
+	}
+	sim.updateIDIndex()
+}
+
+func (sim *Simulation) CalculateForces() {
+	sim.calculateForces(nil)
+}
+
+// Only the particles where active() is true get new VDot and EDot. The
+// neighbours, density, pressure and derivatives are updated for them and
+// their neighbours, the other particles keep theirs with the drifted
+// predictions. nil means all are active.
+func (sim *Simulation) calculateForces(active func(p *Particle) bool) {
+
+	// rebuild or refit the tree to perserve data locality
+	sim.UpdateTree()
+
+	var activeSet, needed []int
+	if active != nil {
+		activeSet = make([]int, 0)
+		for i := range sim.Root.Particles {
+			if active(&sim.Root.Particles[i]) {
+				activeSet = append(activeSet, i)
+			}
+		}
+	}
+	sim.findNearestNeighbours(activeSet)
+	needed = sim.withNeighbours(activeSet)
+
+	// Calculate Nearest Neighbor Density Rho, with grad-h together with H
+	if sim.Config.GradH {
+		sim.parallelForSet(needed, func(i int) {
+			SmoothingLength2D(i, sim, sim.Config.Kernel)
+		})
+		sim.Root.UpdateHMax()
+	} else {
+		sim.parallelForSet(needed, func(i int) {
+			sim.Root.Particles[i].Rho = Density2D(i, sim, sim.Config.Kernel)
+			sim.Root.Particles[i].Omega = 1
+		})
+	}
+
+	// Calculate pressure and speed of sound from the equation of state
+	sim.parallelForSet(needed, func(i int) {
 		p := &sim.Root.Particles[i]
-		p.Rho = Density2D(p, sim, sim.Config.Kernel)
+		eos := sim.Config.EOSOf(sim.State.Species[i])
+		p.P = eos.Pressure(p.Rho, p.EPred)
+		p.C = eos.SoundSpeed(p.Rho, p.EPred)
+	})
+
+	// Wall particles take theirs from the fluid
+	sim.parallelForSet(needed, func(i int) {
+		if sim.State.Wall[i] {
+			ExtrapolateWall2D(i, sim, sim.Config.Kernel)
+		}
+	})
+
+	// Velocity divergence and curl for the viscosity switches
+	if sim.Config.Viscosity.needsDivV() {
+		sim.parallelForSet(needed, func(i int) {
+			VelocityDerivatives2D(i, sim, sim.Config.Kernel)
+		})
 	}
 
-	// Calculate speed of sound c = sqrt(gamma(gamma-1)ePred)
-	// gamma heat capacity ratio = 1 + 2/f
-	{
-		factor := sim.Config.Gamma * (sim.Config.Gamma - 1)
-		for i, _ := range sim.Root.Particles {
-			c := math.Sqrt(factor * sim.Root.Particles[i].EPred)
-			sim.Root.Particles[i].C = c
+	// Surface normals for the surface tension, HMax for its pair search
+	if sim.Config.SurfaceTension.Tension > 0 {
+		if !sim.Config.GradH {
+			sim.Root.UpdateHMax()
 		}
+		sim.parallelForSet(needed, func(i int) {
+			SurfaceNormal2D(i, sim, sim.Config.Kernel)
+		})
 	}
 
 	// Calculate Nearest Neighbor SPH forces (VDot, EDot)
-	for i, _ := range sim.Root.Particles {
-		AccelerationAndEDot2D(&sim.Root.Particles[i], sim, sim.Config.Kernel)
+	sim.parallelForSet(activeSet, func(i int) {
+		p := &sim.Root.Particles[i]
+		if sim.State.Wall[i] {
+			p.VDot, p.EDot = Vec2{}, 0
+			return
+		}
+		if sim.Config.GradH {
+			AccelerationAndEDotGradH2D(i, sim, sim.Config.Kernel)
+		} else {
+			AccelerationAndEDot2D(i, sim, sim.Config.Kernel)
+		}
+	})
+
+	// Barnes-Hut self gravity on top of Config.Acceleration
+	sim.addGravity(active)
+}
+
+// claculate all nearest neighbours, with the periodic boundaries of the config
+func (sim *Simulation) FindNearestNeighbours() {
+	sim.findNearestNeighbours(nil)
+}
+
+// only for the particles in set, all for a nil set
+func (sim *Simulation) findNearestNeighbours(set []int) {
+	k := sim.NNSearchSize()
+	sim.Neighbours.Resize(len(sim.Root.Particles), k)
+
+	// H is the hint for the search, it might be for less neighbours
+	hintScale := 1.0
+	if sim.Config.GradH {
+		hintScale = math.Sqrt(float64(k) / float64(sim.Config.NNSize))
 	}
+
+	sim.parallelForSet(set, func(i int) {
+		p := &sim.Root.Particles[i]
+		p.H *= hintScale
+		p.FindNearestNeighboursPeriodic(sim.Root, sim.Neighbours.Of(i), sim.Config.HorPeriodicity, sim.Config.VertPeriodicity)
+		if math.IsInf(p.H, 1) {
+			p.H = sim.isolatedH()
+		}
+	})
+}
+
+// The particles in set and their neighbours, the ones not in set get their
+// neighbours searched as well. nil stays nil, all particles.
+func (sim *Simulation) withNeighbours(set []int) []int {
+	if set == nil {
+		return nil
+	}
+
+	in := make([]bool, len(sim.Root.Particles))
+	for _, i := range set {
+		in[i] = true
+	}
+	added := make([]int, 0)
+	for _, i := range set {
+		for _, j := range sim.Neighbours.Of(i).Index {
+			if j >= 0 && !in[j] {
+				in[j] = true
+				added = append(added, int(j))
+			}
+		}
+	}
+	sim.findNearestNeighbours(added)
+
+	return append(added, set...)
+}
+
+// H of a particle that found no neighbours at all, the size of the root cell
+// or of the Viewport if there is only a single point
+func (sim *Simulation) isolatedH() float64 {
+	size := sim.Root.UpperRight.Sub(&sim.Root.LowerLeft)
+	if size.X == 0 && size.Y == 0 {
+		size = sim.Config.Viewport[1].Sub(&sim.Config.Viewport[0])
+	}
+	return math.Max(math.Abs(size.X), math.Abs(size.Y))
 }
 
 //
@@ -446,6 +756,16 @@ func (sim *Simulation) TotalEnergy() float64 {
 	return tot
 }
 
+// Sum of v^2 / 2, per mass like TotalEnergy()
+func (sim *Simulation) TotalKineticEnergy() float64 {
+	tot := 0.0
+	for i := range sim.Root.Particles {
+		v := sim.Root.Particles[i].Vel
+		tot += 0.5 * v.Dot(&v)
+	}
+	return tot
+}
+
 func (sim *Simulation) TotalDensity() float64 {
 	tot := 0.0
 	for i := range sim.Root.Particles {
@@ -0,0 +1,122 @@
+/* Surface tension of free-surface liquids
+
+Pairwise cohesion and a curvature term after Akinci et al. (2013), for
+particles a and b closer than the mean kernel support h:
+
+	dv_a/dt += K_ab Tension [ m_b C(r) r_ab / r - (n_a - n_b) ]
+
+r_ab = r_b - r_a. C(r) is the cohesion spline: attractive for r > h/4 and
+repulsive closer, so the particles don't clump. The normals
+
+	n_a = H_a sum_b m_b / rho_b grad_a W_ab
+
+are ~0 inside the fluid and point into it at the surface, the curvature term
+works against bends of the surface. K_ab = 2 rho0 / (rho_a + rho_b) makes up
+for the missing neighbours of surface particles, it is 1 without a
+RestDensity.
+
+The pairs come from the symmetric pair search of grad-h.go also without
+GradH, so the forces of a and b on each other cancel.
+
+Unlike the cohesion the curvature term doesn't scale with the mass, it sums
+over all neighbours. Tension is in the units of the simulation and has to be
+tuned, too much of it blows the particles apart.
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+type SurfaceTension struct {
+	Tension float64 // Surface tension coefficient, 0 turns it off
+	Rho0    float64 // Rest density of the liquid for K_ab, 0 is without
+}
+
+// Cohesion spline normalised to 1 over the disk of radius h
+func cohesion(r, h float64) float64 {
+	if r >= h || r <= 0 {
+		return 0
+	}
+	q := r / h
+	c := pow3(1-q) * q * q * q
+	if q <= 0.5 {
+		c = 2*c - 1.0/64
+	}
+	return 35840 / (209 * math.Pi) * c / (h * h)
+}
+
+// Colour field normal of particle i from its neighbours in sim.Neighbours,
+// see top of file
+func SurfaceNormal2D(i int, sim *Simulation, kernel Kernel) {
+	p := &sim.Root.Particles[i]
+	nns := sim.Neighbours.Of(i)
+
+	n := Vec2{}
+	for j := range nns.Index {
+		if nns.Index[j] < 0 || nns.Index[j] == int32(i) {
+			continue
+		}
+		nn := &sim.Root.Particles[nns.Index[j]]
+
+		// grad_a W = -dW/dr r_ab / r, 0 between particles on top of each other
+		rAB := nns.Pos[j].Sub(&p.Pos)
+		s := 0.0
+		if r := nns.Dists[j]; r > 0 {
+			s = -nn.Mass / nn.Rho * kernelDW(kernel, r, p.H) / r
+		}
+		n.X += s * rAB.X
+		n.Y += s * rAB.Y
+	}
+	sim.State.Normal[i] = n.Mul(p.H)
+}
+
+// Surface tension acceleration of particle i by all particles it pairs with.
+// The pairs are symmetric also without grad-h, unlike the nearest neighbours,
+// so the forces cancel. UpdateHMax() has to be called before.
+func SurfaceTension2D(i int, sim *Simulation) Vec2 {
+	p := &sim.Root.Particles[i]
+	st := &sim.Config.SurfaceTension
+
+	list := pairLists.Get().(*NNList)
+	*list = sim.Root.PairSearchPeriodic(p.Pos, p.H, sim.Config.HorPeriodicity, sim.Config.VertPeriodicity, list.Reset())
+	nns := *list
+
+	acc := Vec2{}
+	for j := range nns.Index {
+		if nns.Index[j] == int32(i) && nns.Dists[j] == 0 {
+			continue
+		}
+		b := int(nns.Index[j])
+		nn := &sim.Root.Particles[b]
+		rAB := nns.Pos[j].Sub(&p.Pos)
+		dv := st.Acceleration(p, nn, sim.State.Normal[i], sim.State.Normal[b], rAB, nns.Dists[j])
+		acc = acc.Add(&dv)
+	}
+
+	pairLists.Put(list)
+	return acc
+}
+
+// Acceleration of a by b at distance r, rAB = rB - rA. nA and nB are their
+// surface normals.
+func (st *SurfaceTension) Acceleration(a, b *Particle, nA, nB, rAB Vec2, r float64) Vec2 {
+	if st.Tension == 0 || r == 0 {
+		return Vec2{}
+	}
+	h := 0.5 * (a.H + b.H)
+	if r >= h {
+		return Vec2{}
+	}
+
+	k := 1.0
+	if st.Rho0 > 0 {
+		k = 2 * st.Rho0 / (a.Rho + b.Rho)
+	}
+
+	acc := rAB.Mul(b.Mass * cohesion(r, h) / r)
+	curvature := nA.Sub(&nB)
+	acc = acc.Sub(&curvature)
+	return acc.Mul(k * st.Tension)
+}
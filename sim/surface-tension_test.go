@@ -0,0 +1,150 @@
+package sim
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCohesionSpline(t *testing.T) {
+	const h = 0.3
+	const n = 100000
+
+	total := 0.0
+	dr := h / n
+	for i := range n {
+		r := (float64(i) + 0.5) * dr
+		total += cohesion(r, h) * 2 * math.Pi * r * dr
+	}
+	if math.Abs(total-1) > 1e-6 {
+		t.Fatalf("cohesion spline integrates to %v, expected 1", total)
+	}
+
+	if cohesion(0.1*h, h) >= 0 || cohesion(0.6*h, h) <= 0 || cohesion(h, h) != 0 {
+		t.Fatalf("cohesion should be repulsive close by, attractive further away and 0 at h")
+	}
+}
+
+// square block of liquid in the middle of the unit square
+func dropSimulation(n int, tension float64) *Simulation {
+	dx := 0.2 / float64(n)
+
+	conf := MakeConfig()
+	conf.Kernel = WendlandC2Kernel2D
+	conf.GradH = true
+	// the background pressure lets the drop fall apart without surface tension
+	conf.EOS = Tait{Rho0: 1, C0: 20, Gamma: 7, P0: 50}
+	conf.SurfaceTension = SurfaceTension{Tension: tension, Rho0: 1}
+	conf.AdaptiveTimeStep = true
+	conf.DeltaTHalf = 0.0002
+
+	ps := make([]Particle, 0, n*n)
+	for i := range n {
+		for j := range n {
+			pos := Vec2{0.4 + (float64(i)+0.5)*dx, 0.4 + (float64(j)+0.5)*dx}
+			ps = append(ps, Particle{Pos: pos, Mass: dx * dx, E: 1})
+		}
+	}
+
+	sim := &Simulation{Config: conf}
+	sim.Root = &Cell{Particles: ps}
+	sim.BuildTree()
+	return sim
+}
+
+func TestSurfaceNormals(t *testing.T) {
+	sim := dropSimulation(20, 1)
+	sim.Step()
+
+	center := Vec2{0.5, 0.5}
+	for i, p := range sim.Root.Particles {
+		normal := sim.State.Normal[i]
+		d := center.Sub(&p.Pos)
+		inside := math.Max(math.Abs(d.X), math.Abs(d.Y)) < 0.1-p.H
+		if inside && normal.Norm() > 0.05 {
+			t.Fatalf("normal %v inside the drop at %v", normal, p.Pos)
+		}
+		// just below the surface they can point out a bit
+		if !inside && normal.Norm() > 0.5 && normal.Dot(&d) <= 0 {
+			t.Fatalf("normal %v at %v does not point into the drop", normal, p.Pos)
+		}
+	}
+}
+
+func TestSurfaceTensionMomentum(t *testing.T) {
+	sim := dropSimulation(20, 10)
+	sim.Step()
+
+	total := Vec2{}
+	scale := 0.0
+	for _, p := range sim.Root.Particles {
+		f := p.VDot.Mul(p.Mass)
+		total = total.Add(&f)
+		scale += f.Norm()
+	}
+	if total.Norm() > 1e-10*scale {
+		t.Fatalf("total force %v should vanish, sum of |f| is %v", total, scale)
+	}
+
+	// without grad-h only the surface tension is symmetric
+	sim = dropSimulation(20, 10)
+	sim.Config.GradH = false
+	sim.Step()
+
+	total = Vec2{}
+	scale = 0.0
+	for i, p := range sim.Root.Particles {
+		acc := SurfaceTension2D(i, sim)
+		f := acc.Mul(p.Mass)
+		total = total.Add(&f)
+		scale += f.Norm()
+	}
+	if scale == 0 || total.Norm() > 1e-10*scale {
+		t.Fatalf("without grad-h the total surface tension force %v should vanish, sum of |f| is %v", total, scale)
+	}
+}
+
+func TestSurfaceTensionHoldsDrop(t *testing.T) {
+	// mean squared distance to the center
+	spread := func(sim *Simulation) float64 {
+		sum := 0.0
+		for _, p := range sim.Root.Particles {
+			d := Vec2{p.Pos.X - 0.5, p.Pos.Y - 0.5}
+			sum += d.Dot(&d)
+		}
+		return sum / float64(len(sim.Root.Particles))
+	}
+
+	free := dropSimulation(20, 0)
+	held := dropSimulation(20, 10)
+	for range 300 {
+		free.Step()
+		held.Step()
+	}
+
+	// 0.2^2 / 6 for the square at the start
+	start := 0.2 * 0.2 / 6
+	if spread(free) < 3*start {
+		t.Fatalf("drop without surface tension should fall apart, spread %v from %v", spread(free), start)
+	}
+	if spread(held) > 2*start {
+		t.Fatalf("surface tension should hold the drop together, spread %v from %v", spread(held), start)
+	}
+}
+
+func TestSurfaceTensionConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "surface-tension.conf")
+	source := "[[Simulation]]\n[SurfaceTension]\nTension 0.5\nRestDensity 1000\n"
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf, err := MakeConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("could not parse surface tension config: %v", err)
+	}
+	if conf.SurfaceTension != (SurfaceTension{Tension: 0.5, Rho0: 1000}) {
+		t.Fatalf("surface tension config not applied: %+v", conf.SurfaceTension)
+	}
+}
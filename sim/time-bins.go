@@ -0,0 +1,223 @@
+/* Individual time steps in power-of-two time bins
+
+A particle in bin b has the time step
+
+	dt_b = 2 * DeltaTHalf / 2^b,   b = 0 .. TimeBins-1
+
+and is put into the biggest step that is below its own ParticleDeltaT(). One
+Step() still advances the simulation by 2 * DeltaTHalf, but in substeps as
+small as the deepest occupied bin. Time is counted in ticks of the smallest
+possible step, so all bins stay synchronized.
+
+Every particle runs its own kick-drift-kick leapfrog: it is kicked by half of
+its step when the step starts and ends, but all particles are drifted every
+substep. Only the particles that end their step get new forces, the density
+and pressure are only updated for them and their neighbours. The others just
+predict VPred and EPred and keep the rest from their last step.
+
+A particle can move to a smaller step whenever its step ends, to a bigger one
+only if the time is a multiple of the bigger step. Neighbours are kept at most
+TIME_BIN_LIMIT bins apart (Saitoh & Makino 2009), a particle with a too big
+step is woken up when a neighbour goes deeper, see limitTimeBins().
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+const TIME_BIN_LIMIT = 2 // Bins neighbours can be apart, a factor 4 in the step
+
+// Number of ticks of the particles step
+func (sim *Simulation) binTicks(bin int) int {
+	return 1 << (sim.Config.TimeBins - 1 - bin)
+}
+
+// Deepest bin, i.e. the smallest time step, that is used by a particle
+func (sim *Simulation) MaxTimeBin() int {
+	bin := 0
+	if sim.Root == nil {
+		return bin
+	}
+	for i := range sim.Root.Particles {
+		bin = max(bin, sim.Root.Particles[i].Bin)
+	}
+	return bin
+}
+
+// Bin of the biggest step smaller than dt, the deepest one if none is small enough
+func (sim *Simulation) TimeBinOf(dt float64) int {
+	dtMax := 2 * sim.Config.DeltaTHalf
+	if dt >= dtMax {
+		return 0
+	}
+	if !(dt > 0) {
+		return sim.Config.TimeBins - 1
+	}
+	bin := int(math.Ceil(math.Log2(dtMax / dt)))
+	return min(bin, sim.Config.TimeBins-1)
+}
+
+// (Re)assigns the bin of a particle at tick, it only gets a bigger step if
+// tick is a multiple of it
+func (sim *Simulation) assignTimeBin(i int, tick int) {
+	bin := sim.TimeBinOf(sim.ParticleDeltaT(i))
+	for tick%sim.binTicks(bin) != 0 {
+		bin++
+	}
+	sim.Root.Particles[i].Bin = bin
+}
+
+func (sim *Simulation) assignTimeBins(tick int) {
+	all := make([]int, len(sim.Root.Particles))
+	for i := range sim.Root.Particles {
+		sim.assignTimeBin(i, tick)
+		all[i] = i
+	}
+	sim.limitTimeBins(tick, all)
+}
+
+// The neighbour limiter of Saitoh & Makino (2009): a particle next to a much
+// smaller step would not see a hot particle coming before it is too late.
+// The particles in ended just got their bins at the end of their step, they
+// and their neighbours go deeper until they are at most TIME_BIN_LIMIT bins
+// apart. Neighbours in the middle of their step are woken up.
+func (sim *Simulation) limitTimeBins(tick int, ended []int) {
+	particles := sim.Root.Particles
+	need := make(map[int]int)
+	limit := func(i, bin int) {
+		if bin > particles[i].Bin && bin > need[i] {
+			need[i] = bin
+		}
+	}
+
+	for _, i := range ended {
+		for _, j := range sim.Neighbours.Of(i).Index {
+			if j < 0 {
+				continue
+			}
+			limit(int(j), particles[i].Bin-TIME_BIN_LIMIT)
+			limit(i, particles[j].Bin-TIME_BIN_LIMIT)
+		}
+	}
+
+	for i, bin := range need {
+		if tick%sim.binTicks(particles[i].Bin) == 0 {
+			// at the start of its step anyway
+			particles[i].Bin = bin
+		} else {
+			sim.wakeUp(i, bin, tick)
+		}
+	}
+}
+
+// Ends the step of particle i early at the next tick of bin. It got the first
+// kick for the whole step and drifted with it, both are corrected so that the
+// kicks add up to the shortened step again.
+func (sim *Simulation) wakeUp(i, bin, tick int) {
+	p := &sim.Root.Particles[i]
+	dtTick := 2 * sim.Config.DeltaTHalf / float64(sim.binTicks(0))
+
+	ticks := sim.binTicks(p.Bin)
+	start := tick - tick%ticks
+	end := (tick/sim.binTicks(bin) + 1) * sim.binTicks(bin)
+
+	// the second kick will be for half of the new bin
+	firstKick := float64(end-start) - 0.5*float64(sim.binTicks(bin))
+	dh := (firstKick - 0.5*float64(ticks)) * dtTick
+
+	adh := p.VDot.Mul(dh)
+	p.Vel = p.Vel.Add(&adh)
+	p.E = p.E + p.EDot*dh
+
+	drift := adh.Mul(float64(tick-start) * dtTick)
+	p.Pos = p.Pos.Add(&drift)
+
+	p.Bin = bin
+}
+
+// First half of the kick-drift-kick, for the particles starting their step
+func (sim *Simulation) firstKick(i int, dtTick float64) {
+	p := &sim.Root.Particles[i]
+	halfStep := 0.5 * dtTick * float64(sim.binTicks(p.Bin))
+	adt := p.VDot.Mul(halfStep)
+	p.Vel = p.Vel.Add(&adt)
+	p.E = p.E + p.EDot*halfStep
+}
+
+// One step of 2 * DeltaTHalf with individual time steps, see top of file
+func (sim *Simulation) stepTimeBins() {
+	dtTick := 2 * sim.Config.DeltaTHalf / float64(sim.binTicks(0))
+	substeps := 0
+
+	// everyone starts a step
+	for i := range sim.Root.Particles {
+		sim.firstKick(i, dtTick)
+	}
+
+	for tick := 0; tick < sim.binTicks(0); {
+
+		// next time a particle ends its step
+		next := sim.binTicks(0)
+		for i := range sim.Root.Particles {
+			ticks := sim.binTicks(sim.Root.Particles[i].Bin)
+			next = min(next, (tick/ticks+1)*ticks)
+		}
+		dt := dtTick * float64(next-tick)
+
+		// drift all, the inactive ones only need the predictions
+		for i := range sim.Root.Particles {
+			p := &sim.Root.Particles[i]
+
+			vdt := p.Vel.Mul(dt)
+			p.Pos = p.Pos.Add(&vdt)
+
+			adt := p.VDot.Mul(dt)
+			p.VPred = p.VPred.Add(&adt)
+			p.EPred = p.EPred + p.EDot*dt
+		}
+
+		sim.applyBoundaries()
+
+		tick = next
+		active := func(p *Particle) bool {
+			return tick%sim.binTicks(p.Bin) == 0
+		}
+
+		sim.calculateForces(active)
+		substeps++
+
+		// second kick for the particles ending their step
+		ended := make([]int, 0)
+		for i := range sim.Root.Particles {
+			p := &sim.Root.Particles[i]
+			if !active(p) {
+				continue
+			}
+			halfStep := 0.5 * dtTick * float64(sim.binTicks(p.Bin))
+			adt := p.VDot.Mul(halfStep)
+			p.Vel = p.Vel.Add(&adt)
+			p.E = p.E + p.EDot*halfStep
+
+			p.VPred = p.Vel
+			p.EPred = p.E
+
+			sim.Config.Viscosity.UpdateAlpha(p, &sim.State.Viscous[i], 2*halfStep)
+			sim.assignTimeBin(i, tick)
+			ended = append(ended, i)
+		}
+		sim.limitTimeBins(tick, ended)
+
+		// and the first one of their next step, the last one ends with all
+		// particles synchronized
+		if tick < sim.binTicks(0) {
+			for _, i := range ended {
+				sim.firstKick(i, dtTick)
+			}
+		}
+	}
+
+	sim.Time += 2 * sim.Config.DeltaTHalf
+	sim.TimeSteps = append(sim.TimeSteps, TimeStep{Time: sim.Time, DeltaT: 2 * sim.Config.DeltaTHalf, Substeps: substeps})
+}
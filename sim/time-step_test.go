@@ -0,0 +1,161 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func adaptiveTestConfig() SphConfig {
+	conf := MakeConfig()
+	conf.Start = []ParticleSource{UniformRectSpawner{
+		UpperLeft:  Vec2{0.1, 0.1},
+		LowerRight: Vec2{0.6, 0.9},
+		NParticles: 1000,
+	}}
+	conf.DeltaTHalf = 1 // so the criteria decide
+	conf.AdaptiveTimeStep = true
+	return conf
+}
+
+func TestAdaptiveTimeStep(t *testing.T) {
+	sim := MakeSimulationFromConf(adaptiveTestConfig())
+	for range 5 {
+		sim.Step()
+	}
+
+	total := 0.0
+	for _, step := range sim.TimeSteps {
+		if step.DeltaT > 2*sim.Config.DeltaTHalf || step.DeltaT <= 0 {
+			t.Fatalf("time step %v not in (0, %v]", step.DeltaT, 2*sim.Config.DeltaTHalf)
+		}
+		total += step.DeltaT
+		if math.Abs(total-step.Time) > 1e-12 {
+			t.Fatalf("recorded time %v is not the sum of the time steps %v", step.Time, total)
+		}
+	}
+	if len(sim.TimeSteps) != 5 || sim.Time != sim.TimeSteps[4].Time {
+		t.Fatalf("expected 5 recorded steps ending at %v, got %v", sim.Time, sim.TimeSteps)
+	}
+
+	// one hot and fast particle has to shrink the step for everyone, the
+	// time step is from the forces of the last step, so it takes one step
+	cold := sim.AdaptiveDeltaTHalf()
+	sim.Root.Particles[10].Vel = Vec2{50, 0}
+	sim.Root.Particles[10].E = 100
+	sim.Step()
+	sim.Step()
+
+	hot := sim.TimeSteps[len(sim.TimeSteps)-1].DeltaT / 2
+	if hot > cold/10 {
+		t.Fatalf("hot particle should limit the time step: %v before, %v after", cold, hot)
+	}
+}
+
+func TestFixedTimeStep(t *testing.T) {
+	conf := adaptiveTestConfig()
+	conf.AdaptiveTimeStep = false
+
+	sim := MakeSimulationFromConf(conf)
+	for range 3 {
+		sim.Step()
+	}
+	for _, step := range sim.TimeSteps {
+		if step.DeltaT != 2*conf.DeltaTHalf {
+			t.Fatalf("fixed time step %v changed to %v", 2*conf.DeltaTHalf, step.DeltaT)
+		}
+	}
+}
+
+// one hot particle in a cold box, the bins assigned for it
+func hotSimulation(conf SphConfig) (*Simulation, int) {
+	sim := MakeSimulationFromConf(conf)
+	sim.Step()
+
+	hot := &sim.Root.Particles[10]
+	hot.Vel = Vec2{10, 0}
+	hot.VPred = hot.Vel
+	hot.E = 10
+	hot.EPred = 10
+	sim.CalculateForces()
+	sim.assignTimeBins(0)
+	return &sim, hot.ID
+}
+
+func TestTimeBins(t *testing.T) {
+	conf := adaptiveTestConfig()
+	conf.AdaptiveTimeStep = false
+	conf.HierarchicalTimeStep = true
+	conf.DeltaTHalf = 0.01
+	conf.TimeBins = 8
+
+	// one hot particle goes into a deep bin, the rest stays
+	sim, hotID := hotSimulation(conf)
+	deepest := sim.MaxTimeBin()
+	sim.Step()
+
+	last := sim.TimeSteps[len(sim.TimeSteps)-1]
+	if last.DeltaT != 2*conf.DeltaTHalf || math.Abs(sim.Time-4*conf.DeltaTHalf) > 1e-12 {
+		t.Fatalf("a step has to advance by %v, got %v to %v", 2*conf.DeltaTHalf, last.DeltaT, sim.Time)
+	}
+
+	inHotBin := 0
+	hotBin := sim.ParticleByID(hotID).Bin
+	for i := range sim.Root.Particles {
+		if sim.Root.Particles[i].Bin == hotBin {
+			inHotBin++
+		}
+	}
+	if hotBin == 0 || inHotBin > len(sim.Root.Particles)/10 {
+		t.Fatalf("hot particle in bin %v together with %v others", hotBin, inHotBin-1)
+	}
+	if last.Substeps < 2 || last.Substeps > 1<<(conf.TimeBins-1) {
+		t.Fatalf("expected between 2 and %v substeps, got %v", 1<<(conf.TimeBins-1), last.Substeps)
+	}
+
+	// every particle keeps its own limit, if the bins are deep enough, and
+	// is at most TIME_BIN_LIMIT bins above its neighbours
+	for i := range sim.Root.Particles {
+		p := &sim.Root.Particles[i]
+		dt := 2 * conf.DeltaTHalf / float64(int(1)<<p.Bin)
+		if p.Bin < conf.TimeBins-1 && dt > sim.ParticleDeltaT(i)*(1+1e-12) {
+			t.Fatalf("particle %v in bin %v with step %v above its limit %v", i, p.Bin, dt, sim.ParticleDeltaT(i))
+		}
+		for _, j := range sim.Neighbours.Of(i).Index {
+			if j >= 0 && sim.Root.Particles[j].Bin > p.Bin+TIME_BIN_LIMIT {
+				t.Fatalf("particle %v in bin %v next to one in bin %v", i, p.Bin, sim.Root.Particles[j].Bin)
+			}
+		}
+	}
+
+	// the same with everyone in the step of the hot particle
+	conf.TimeBins = 1
+	ref, _ := hotSimulation(conf)
+	ref.Config.DeltaTHalf = conf.DeltaTHalf / float64(int(1)<<deepest)
+	for range 1 << deepest {
+		ref.Step()
+	}
+
+	energy := sim.TotalKineticEnergy() + sim.TotalEnergy()
+	refEnergy := ref.TotalKineticEnergy() + ref.TotalEnergy()
+	if math.Abs(energy-refEnergy) > 0.002*refEnergy {
+		t.Fatalf("energy %v with time bins, %v with a single one", energy, refEnergy)
+	}
+}
+
+func TestOneTimeBin(t *testing.T) {
+	conf := adaptiveTestConfig()
+	conf.AdaptiveTimeStep = false
+	conf.HierarchicalTimeStep = true
+	conf.DeltaTHalf = 0.001
+	conf.TimeBins = 1
+
+	sim := MakeSimulationFromConf(conf)
+	for range 3 {
+		sim.Step()
+	}
+	for _, step := range sim.TimeSteps {
+		if step.Substeps != 1 {
+			t.Fatalf("a single time bin needs one substep, got %v", step.Substeps)
+		}
+	}
+}
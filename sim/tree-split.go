@@ -0,0 +1,169 @@
+/* Split strategies for Treebuild()
+
+A SplitStrategy decides how a cell is divided into its Lower and Upper
+child. The default AlternatingSplit cuts in the middle of the cell and
+alternates the axis with every level. For clustered particles (a dam-break
+column, a point source) the other strategies give shallower trees.
+*/
+
+package sim
+
+import (
+	"math"
+	"slices"
+)
+
+// Gets the cell to split and the orientation suggested by the parent (the
+// other one of the parent). Returns the orientation and the position of the
+// cut along that axis, particles <= mid end up in the Lower child.
+type SplitStrategy func(cell *Cell, orientation Orientation) (Orientation, float64)
+
+// For selecting the strategy by name in the config
+var SplitStrategies = map[string]SplitStrategy{
+	"Alternating": AlternatingSplit,
+	"LongestAxis": LongestAxisSplit,
+	"Median":      MedianSplit,
+	"Variance":    VarianceSplit,
+}
+
+// Alternates Vertical/Horizontal and cuts at SPLIT_FRACTION of the cell
+func AlternatingSplit(cell *Cell, orientation Orientation) (Orientation, float64) {
+	return orientation, splitFraction(cell, orientation)
+}
+
+// Cuts the longest dimension of the cell at SPLIT_FRACTION
+func LongestAxisSplit(cell *Cell, orientation Orientation) (Orientation, float64) {
+	width := cell.UpperRight.X - cell.LowerLeft.X
+	height := cell.UpperRight.Y - cell.LowerLeft.Y
+
+	if width > height {
+		orientation = Horizontal
+	} else if height > width {
+		orientation = Vertical
+	}
+	return orientation, splitFraction(cell, orientation)
+}
+
+// Alternates Vertical/Horizontal and cuts at the median particle, so both
+// children get about the same number of particles. If the median is the
+// largest coordinate the Upper child would stay empty, then it cuts at the
+// next smaller one, or in the middle if all are the same.
+func MedianSplit(cell *Cell, orientation Orientation) (Orientation, float64) {
+	coords := particleCoords(cell.Particles, orientation)
+	if len(coords) == 0 {
+		return orientation, splitFraction(cell, orientation)
+	}
+	k := (len(coords) - 1) / 2
+	mid := selectKth(coords, k)
+
+	largest := slices.Max(coords)
+	if mid < largest {
+		return orientation, mid
+	}
+
+	below := math.Inf(-1)
+	for _, x := range coords {
+		if x < largest && x > below {
+			below = x
+		}
+	}
+	if math.IsInf(below, -1) {
+		return orientation, splitFraction(cell, orientation)
+	}
+	return orientation, below
+}
+
+// Cuts the axis with the largest variance of the particle positions at the
+// mean position. If the mean is not strictly between the smallest and largest
+// coordinate (precision, all on top of each other) one child would stay
+// empty, then it cuts at the median instead.
+func VarianceSplit(cell *Cell, orientation Orientation) (Orientation, float64) {
+	n := float64(len(cell.Particles))
+	if n == 0 {
+		return orientation, splitFraction(cell, orientation)
+	}
+
+	// two passes, E[x^2] - E[x]^2 cancels for particles far from the origin
+	var mean Vec2
+	for i := range cell.Particles {
+		mean = mean.Add(&cell.Particles[i].Pos)
+	}
+	mean = mean.Mul(1 / n)
+
+	var variance Vec2
+	for i := range cell.Particles {
+		d := cell.Particles[i].Pos.Sub(&mean)
+		variance.X += d.X * d.X
+		variance.Y += d.Y * d.Y
+	}
+
+	if variance.X > variance.Y {
+		orientation = Horizontal
+	} else if variance.Y > variance.X {
+		orientation = Vertical
+	}
+
+	coords := particleCoords(cell.Particles, orientation)
+	mid := mean.X
+	if orientation == Vertical {
+		mid = mean.Y
+	}
+	if mid > slices.Min(coords) && mid < slices.Max(coords) {
+		return orientation, mid
+	}
+	return MedianSplit(cell, orientation)
+}
+
+func splitFraction(cell *Cell, orientation Orientation) float64 {
+	if orientation == Vertical {
+		return SPLIT_FRACTION*cell.LowerLeft.Y + (1-SPLIT_FRACTION)*cell.UpperRight.Y
+	}
+	return SPLIT_FRACTION*cell.LowerLeft.X + (1-SPLIT_FRACTION)*cell.UpperRight.X
+}
+
+func particleCoords(particles []Particle, orientation Orientation) []float64 {
+	coords := make([]float64, len(particles))
+	for i := range particles {
+		if orientation == Vertical {
+			coords[i] = particles[i].Pos.Y
+		} else {
+			coords[i] = particles[i].Pos.X
+		}
+	}
+	return coords
+}
+
+// Quickselect: returns the k-th smallest value, reorders xs.
+// Small arrays are just sorted.
+func selectKth(xs []float64, k int) float64 {
+	for len(xs) > 16 {
+		pivot := xs[len(xs)/2]
+
+		// three way partition: < pivot | == pivot | > pivot
+		lt, i, gt := 0, 0, len(xs)
+		for i < gt {
+			if xs[i] < pivot {
+				xs[lt], xs[i] = xs[i], xs[lt]
+				lt++
+				i++
+			} else if xs[i] > pivot {
+				gt--
+				xs[gt], xs[i] = xs[i], xs[gt]
+			} else {
+				i++
+			}
+		}
+
+		if k < lt {
+			xs = xs[:lt]
+		} else if k >= gt {
+			xs = xs[gt:]
+			k -= gt
+		} else {
+			return pivot
+		}
+	}
+
+	slices.Sort(xs)
+	return xs[k]
+}
@@ -0,0 +1,343 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// dense column, a point like source and some background particles
+func clusteredParticles() []Particle {
+	column := UniformRectSpawner{
+		UpperLeft:  Vec2{0.05, 0.4},
+		LowerRight: Vec2{0.2, 1},
+		NParticles: 3000,
+	}
+	source := UniformRectSpawner{
+		UpperLeft:  Vec2{0.7, 0.2},
+		LowerRight: Vec2{0.701, 0.201},
+		NParticles: 500,
+	}
+	background := UniformRectSpawner{
+		LowerRight: Vec2{1, 1},
+		NParticles: 500,
+	}
+
+	ps := column.Spawn(0)
+	ps = append(ps, source.Spawn(0)...)
+	ps = append(ps, background.Spawn(0)...)
+	return ps
+}
+
+// every particle has to be inside the bounds of its leaf
+func checkLeafBounds(cell *Cell, t *testing.T) {
+	if cell.Upper == nil && cell.Lower == nil {
+		for _, p := range cell.Particles {
+			if p.Pos.X < cell.LowerLeft.X || p.Pos.Y < cell.LowerLeft.Y || p.Pos.X > cell.UpperRight.X || p.Pos.Y > cell.UpperRight.Y {
+				t.Fatalf("Particle `%v` is outside of its leaf `%v` `%v`", p.Pos, cell.LowerLeft, cell.UpperRight)
+			}
+		}
+		return
+	}
+	if cell.Upper != nil {
+		checkLeafBounds(cell.Upper, t)
+	}
+	if cell.Lower != nil {
+		checkLeafBounds(cell.Lower, t)
+	}
+}
+
+// the ranges, the merged leaves and the bounding spheres after a refit
+func checkRefitted(root, cell *Cell, t *testing.T) {
+	if len(cell.Particles) > 0 && &cell.Particles[0] != &root.Particles[cell.Offset] {
+		t.Fatalf("cell at %v does not hold its range of the root", cell.Offset)
+	}
+	for _, p := range cell.Particles {
+		if Dist(p.Pos, cell.BCenter) > cell.BRadius*(1+1e-9)+1e-12 {
+			t.Fatalf("Particle `%v` is outside of the bounding sphere `%v` `%v`", p.Pos, cell.BCenter, cell.BRadius)
+		}
+	}
+	if cell.Upper == nil && cell.Lower == nil {
+		if len(cell.Particles) == 0 {
+			t.Fatalf("empty leaf `%v` `%v` was not removed", cell.LowerLeft, cell.UpperRight)
+		}
+		return
+	}
+	if len(cell.Particles) <= MAX_PARTICLES_PER_CELL {
+		t.Fatalf("cell with %v particles was not merged into a leaf", len(cell.Particles))
+	}
+	n := 0
+	for _, child := range [2]*Cell{cell.Lower, cell.Upper} {
+		if child != nil {
+			if child.Offset != cell.Offset+n {
+				t.Fatalf("child at %v, expected %v", child.Offset, cell.Offset+n)
+			}
+			n += len(child.Particles)
+			checkRefitted(root, child, t)
+		}
+	}
+	if n != len(cell.Particles) {
+		t.Fatalf("children hold %v particles of %v", n, len(cell.Particles))
+	}
+}
+
+func TestSplitStrategies(t *testing.T) {
+	for name, split := range SplitStrategies {
+		ps := clusteredParticles()
+		root := MakeCellsInBox(ps, Vertical, split, Vec2{0, 0}, Vec2{1, 1})
+
+		stats := root.Stats()
+		if stats.MaxOccupancy > MAX_PARTICLES_PER_CELL {
+			t.Fatalf("%v: leaf has %v particles, expected at most %v", name, stats.MaxOccupancy, MAX_PARTICLES_PER_CELL)
+		}
+		if stats.MinOccupancy < 1 {
+			t.Fatalf("%v: got an empty leaf", name)
+		}
+
+		checkLeafBounds(root, t)
+
+		for i, p := range root.Particles {
+			if !isInsideAny(p.Pos, root) {
+				t.Fatalf("%v: Particle %v `%v` is not inside any Cell!", name, i, p.Pos)
+			}
+		}
+	}
+}
+
+func TestMedianSplitBalanced(t *testing.T) {
+	alternating := MakeCells(clusteredParticles(), Vertical)
+	median := MakeCellsInBox(clusteredParticles(), Vertical, MedianSplit, Vec2{0, 0}, Vec2{1, 1})
+
+	if median.Depth() >= alternating.Depth() {
+		t.Fatalf("expected median split to give a shallower tree, got depth %v vs %v", median.Depth(), alternating.Depth())
+	}
+}
+
+// the median is the largest coordinate on both axes
+func TestMedianSplitAtLargest(t *testing.T) {
+	ps := make([]Particle, 0, 9)
+	for range 5 {
+		ps = append(ps, Particle{Pos: Vec2{1, 1}})
+	}
+	for range 2 {
+		ps = append(ps, Particle{Pos: Vec2{0, 1}}, Particle{Pos: Vec2{1, 0}})
+	}
+
+	root := MakeCellsInBox(ps, Vertical, MedianSplit, Vec2{0, 0}, Vec2{1, 1})
+
+	if root.Lower == nil || root.Upper == nil {
+		t.Fatalf("expected the root to be split in two")
+	}
+	checkLeafBounds(root, t)
+	for i, p := range root.Particles {
+		if !isInsideAny(p.Pos, root) {
+			t.Fatalf("Particle %v `%v` is not inside any Cell!", i, p.Pos)
+		}
+	}
+}
+
+// the mean rounds to the largest coordinate, E[x^2] - E[x]^2 is only noise
+func TestVarianceSplitAtLargest(t *testing.T) {
+	next := math.Nextafter(1e8, math.Inf(1))
+	ps := make([]Particle, 0, 9)
+	for range 4 {
+		ps = append(ps, Particle{Pos: Vec2{1e8, 0.5}})
+	}
+	for range 5 {
+		ps = append(ps, Particle{Pos: Vec2{next, 0.5}})
+	}
+
+	root := MakeCellsInBox(ps, Vertical, VarianceSplit, Vec2{0, 0}, Vec2{1, 1})
+
+	if root.Lower == nil || root.Upper == nil {
+		t.Fatalf("expected the root to be split in two")
+	}
+	checkLeafBounds(root, t)
+}
+
+func TestSelectKth(t *testing.T) {
+	xs := []float64{5, 3, 3, 9, 1, 0, 7, 3, 3, 2, 8, 6, 4, 4, 3, 2, 1, 0, 11, 3, 5}
+	sorted := []float64{0, 0, 1, 1, 2, 2, 3, 3, 3, 3, 3, 3, 4, 4, 5, 5, 6, 7, 8, 9, 11}
+
+	for k := range xs {
+		ys := make([]float64, len(xs))
+		copy(ys, xs)
+		if x := selectKth(ys, k); x != sorted[k] {
+			t.Fatalf("expected %v for k=%v, got %v", sorted[k], k, x)
+		}
+	}
+}
+
+func TestRefit(t *testing.T) {
+	for name, split := range SplitStrategies {
+		ps := clusteredParticles()
+		root := MakeCellsInBox(ps, Vertical, split, Vec2{0, 0}, Vec2{1, 1})
+
+		for step := range 10 {
+			for i := range root.Particles {
+				p := &root.Particles[i]
+				p.Pos.X = min(max(p.Pos.X+0.01*(rand.Float64()-0.5), 0), 1)
+				p.Pos.Y = min(max(p.Pos.Y+0.01*(rand.Float64()-0.5), 0), 1)
+			}
+
+			if !root.Refit(split) {
+				t.Fatalf("%v: step %v: refit failed, but all particles are inside the root", name, step)
+			}
+
+			stats := root.Stats()
+			if stats.MaxOccupancy > MAX_PARTICLES_PER_CELL {
+				t.Fatalf("%v: step %v: leaf has %v particles, expected at most %v", name, step, stats.MaxOccupancy, MAX_PARTICLES_PER_CELL)
+			}
+
+			checkLeafBounds(root, t)
+			checkRefitted(root, root, t)
+
+			for i, p := range root.Particles {
+				if !isInsideAny(p.Pos, root) {
+					t.Fatalf("%v: step %v: Particle %v `%v` is not inside any Cell!", name, step, i, p.Pos)
+				}
+			}
+		}
+	}
+}
+
+func TestRefitLocal(t *testing.T) {
+	root := MakeCellsInBox(clusteredParticles(), Vertical, MedianSplit, Vec2{0, 0}, Vec2{1, 1})
+	upper := root.Upper
+	before := append([]Particle{}, upper.Particles...)
+
+	// into the neighbouring leaf below the lower half of the root
+	leaf := root.Lower
+	for leaf.Lower != nil {
+		leaf = leaf.Lower
+	}
+	p := &leaf.Particles[0]
+	if leaf.SplitAxis == Vertical {
+		p.Pos.Y = leaf.UpperRight.Y + 1e-9
+	} else {
+		p.Pos.X = leaf.UpperRight.X + 1e-9
+	}
+
+	if !root.Refit(MedianSplit) {
+		t.Fatalf("refit failed, but all particles are inside the root")
+	}
+	checkRefitted(root, root, t)
+
+	// the other half is not touched
+	if root.Upper != upper || len(upper.Particles) != len(before) {
+		t.Fatalf("the upper half of the root was rebuilt")
+	}
+	for i := range before {
+		if upper.Particles[i] != before[i] {
+			t.Fatalf("particle %v of the upper half moved", i)
+		}
+	}
+	if root.Imbalance() != 1 {
+		t.Fatalf("imbalance %v after moving one particle", root.Imbalance())
+	}
+}
+
+func TestRefitImbalance(t *testing.T) {
+	root := MakeCellsInBox(clusteredParticles(), Vertical, MedianSplit, Vec2{0, 0}, Vec2{1, 1})
+	if root.Imbalance() != 1 {
+		t.Fatalf("imbalance %v right after the build", root.Imbalance())
+	}
+
+	// half of the upper half goes over to the lower one
+	for i := range root.Upper.Particles {
+		p := &root.Upper.Particles[i]
+		if i%2 == 1 {
+			continue
+		}
+		if root.SplitAxis == Vertical {
+			p.Pos.Y = root.SplitAt - 0.01
+		} else {
+			p.Pos.X = root.SplitAt - 0.01
+		}
+	}
+	if !root.Refit(MedianSplit) {
+		t.Fatalf("refit failed, but all particles are inside the root")
+	}
+	checkRefitted(root, root, t)
+	if root.Imbalance() < 1.5 {
+		t.Fatalf("imbalance %v after half of the upper half moved over", root.Imbalance())
+	}
+}
+
+func TestRefitOutsideRoot(t *testing.T) {
+	root := MakeCells(clusteredParticles(), Vertical)
+	root.Particles[17].Pos.X += 10
+
+	if root.Refit(AlternatingSplit) {
+		t.Fatalf("expected refit to fail for a particle outside of the root cell")
+	}
+}
+
+func TestSortParticles(t *testing.T) {
+	for name, key := range ParticleOrders {
+		if key == nil {
+			continue
+		}
+
+		ps := clusteredParticles()
+		for i := range ps {
+			ps[i].Rho = float64(i) // tag to check we got a permutation
+		}
+
+		lowerLeft, upperRight := ParticleBounds(ps)
+		SortParticles(ps, key, lowerLeft, upperRight)
+
+		seen := make([]bool, len(ps))
+		for i := range ps {
+			if seen[int(ps[i].Rho)] {
+				t.Fatalf("%v: particle %v is there twice after sorting", name, int(ps[i].Rho))
+			}
+			seen[int(ps[i].Rho)] = true
+
+			if i > 0 && key(ps[i-1].Pos, lowerLeft, upperRight) > key(ps[i].Pos, lowerLeft, upperRight) {
+				t.Fatalf("%v: particles %v and %v are not sorted", name, i-1, i)
+			}
+		}
+	}
+}
+
+// neighbouring keys on the Hilbert curve are neighbouring grid cells
+func TestHilbertKeyContinuous(t *testing.T) {
+	const n = 1 << 4
+	lowerLeft, upperRight := Vec2{0, 0}, Vec2{n, n}
+
+	positions := make(map[uint64][2]int)
+	for x := range n {
+		for y := range n {
+			// only keep the first 4 levels of the curve
+			pos := Vec2{float64(x) + 0.5, float64(y) + 0.5}
+			key := HilbertKey(pos, lowerLeft, upperRight) >> (2 * (CURVE_BITS - 4))
+			positions[key] = [2]int{x, y}
+		}
+	}
+
+	if len(positions) != n*n {
+		t.Fatalf("expected %v different keys, got %v", n*n, len(positions))
+	}
+
+	for k := uint64(1); k < n*n; k++ {
+		a, b := positions[k-1], positions[k]
+		dist := max(a[0]-b[0], b[0]-a[0]) + max(a[1]-b[1], b[1]-a[1])
+		if dist != 1 {
+			t.Fatalf("keys %v and %v are not neighbours: %v %v", k-1, k, a, b)
+		}
+	}
+}
+
+// the Morton order visits the cells the same way MakeCells splits them
+func TestMortonKeyFollowsTree(t *testing.T) {
+	lowerLeft, upperRight := Vec2{0, 0}, Vec2{1, 1}
+
+	// upper half in y comes after the lower half, independent of x
+	if MortonKey(Vec2{0.9, 0.4}, lowerLeft, upperRight) > MortonKey(Vec2{0.1, 0.6}, lowerLeft, upperRight) {
+		t.Fatalf("Morton key should split on y first")
+	}
+	if MortonKey(Vec2{0.4, 0.1}, lowerLeft, upperRight) > MortonKey(Vec2{0.6, 0.1}, lowerLeft, upperRight) {
+		t.Fatalf("Morton key should split on x second")
+	}
+}
@@ -0,0 +1,234 @@
+/* Artificial viscosity
+
+Monaghan (1992) between approaching particles a and b:
+
+	mu_ab = h_ab v_ab . r_ab / (r_ab^2 + EtaSq)
+	Pi_ab = (-alpha c_ab mu_ab + beta mu_ab^2) / rho_ab
+
+With a Switch every particle has its own alpha between AlphaMin and AlphaMax,
+high in shocks and decaying to AlphaMin elsewhere over the time H / (Decay c).
+Alpha is only the starting value then, Beta keeps its ratio to it (Beta
+itself with Alpha 0).
+
+  - MorrisMonaghan: d alpha/dt = -(alpha - AlphaMin) / tau + max(-div v, 0) (AlphaMax - alpha)
+  - CullenDehnen: alpha jumps to AlphaMax h^2 A / (h^2 A + c^2) with
+    A = max(-d(div v)/dt, 0) if that is higher, otherwise decays to it
+
+The Balsara limiter multiplies Pi_ab with (f_a + f_b) / 2,
+
+	f = |div v| / (|div v| + |curl v| + 0.0001 c / H)
+
+which is ~0 in shear flows and ~1 in compressions.
+
+Mu > 0 adds the physical viscosity of a Newtonian liquid (Morris et al. 1997),
+for laminar flows and not for shocks:
+
+	dv_a/dt += sum_b m_b (mu_a + mu_b) r_ab . grad_a W_ab / (rho_a rho_b (r_ab^2 + 0.01 h_ab^2)) (v_a - v_b)
+
+and the heat that goes with it. It is independent of alpha, for a liquid
+the artificial viscosity is usually turned off with Alpha 0 and Beta 0. With
+species every particle has the mu of its species, see species.go.
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+type ViscositySwitch int
+
+const (
+	NoSwitch ViscositySwitch = iota
+	MorrisMonaghan
+	CullenDehnen
+)
+
+var ViscositySwitches = map[string]ViscositySwitch{
+	"None":           NoSwitch,
+	"MorrisMonaghan": MorrisMonaghan,
+	"CullenDehnen":   CullenDehnen,
+}
+
+type Viscosity struct {
+	Alpha float64 // Linear term, the starting value with a Switch
+	Beta  float64 // Quadratic term, for shocks
+	EtaSq float64 // Added to r^2 against the singularity
+
+	Switch   ViscositySwitch
+	AlphaMin float64
+	AlphaMax float64
+	Decay    float64 // alpha decays over H / (Decay c)
+
+	Balsara bool // Shear limiter
+
+	Mu float64 // Dynamic viscosity of the physical term, 0 turns it off
+}
+
+// Per particle state of the switch and the limiter, see ParticleState
+type ViscousState struct {
+	Alpha   float64 // Own alpha with a Switch
+	DivV    float64 // Velocity divergence
+	CurlV   float64 // Velocity curl
+	DivVOld float64 // DivV at the last alpha update
+
+	hasDivV bool // DivV was calculated before, see VelocityDerivatives2D()
+}
+
+// the constants used so far, no switch and no limiter
+func MakeViscosity() Viscosity {
+	return Viscosity{
+		Alpha:    0.75,
+		Beta:     1.5,
+		EtaSq:    0.01,
+		AlphaMin: 0.05,
+		AlphaMax: 1.5,
+		Decay:    0.2,
+	}
+}
+
+// Velocity derivatives are only needed for the switches
+func (visc *Viscosity) needsDivV() bool {
+	return visc.Switch != NoSwitch || visc.Balsara
+}
+
+// alpha and beta of a particle with the state s
+func (visc *Viscosity) alphaBeta(s *ViscousState) (float64, float64) {
+	if visc.Switch == NoSwitch {
+		return visc.Alpha, visc.Beta
+	}
+	if visc.Alpha == 0 {
+		return s.Alpha, visc.Beta
+	}
+	return s.Alpha, visc.Beta * s.Alpha / visc.Alpha
+}
+
+// Pi_ab and mu_ab, both 0 if a and b move apart. rAB = rB - rA, vAB = vB - vA.
+// sA and sB are the states of a and b.
+func (visc *Viscosity) Pi(a, b *Particle, sA, sB *ViscousState, rAB, vAB Vec2) (pi, mu float64) {
+	dot := vAB.Dot(&rAB)
+	if dot >= 0 {
+		return 0, 0
+	}
+
+	alphaA, betaA := visc.alphaBeta(sA)
+	alphaB, betaB := visc.alphaBeta(sB)
+	alpha := 0.5 * (alphaA + alphaB)
+	beta := 0.5 * (betaA + betaB)
+
+	cAB := 0.5 * (a.C + b.C)
+	rhoAB := 0.5 * (a.Rho + b.Rho)
+	hAB := 0.5 * (a.H + b.H)
+	mu = dot * hAB / (rAB.Dot(&rAB) + visc.EtaSq)
+	pi = (-alpha*cAB*mu + beta*mu*mu) / rhoAB
+
+	if visc.Balsara {
+		pi *= 0.5 * (balsara(a, sA) + balsara(b, sB))
+	}
+	return pi, mu
+}
+
+func balsara(p *Particle, s *ViscousState) float64 {
+	div := math.Abs(s.DivV)
+	norm := div + math.Abs(s.CurlV) + 0.0001*p.C/p.H
+	if !(norm > 0) {
+		return 1
+	}
+	return div / norm
+}
+
+// Factor nu_ab >= 0 of the physical viscosity, dv_a/dt += m_b nu_ab v_ab and
+// du_a/dt += m_b nu_ab v_ab^2 / 2 with v_ab = v_b - v_a. muA and muB are the
+// dynamic viscosities of a and b, dW is dW/dr at r, h the kernel support.
+func Morris(a, b *Particle, muA, muB, r, dW, h float64) float64 {
+	if muA+muB == 0 || r == 0 {
+		return 0
+	}
+	// 0.01 h^2 with the smoothing length ~ H/2
+	etaSq := 0.0025 * h * h
+	return -(muA + muB) * r * dW / (a.Rho * b.Rho * (r*r + etaSq))
+}
+
+// Monaghan 1992 with the velocity for the Courant condition, muMax is the
+// strongest approach of a neighbour
+func (visc *Viscosity) SignalSpeed(p *Particle, s *ViscousState, muMax float64) float64 {
+	alpha, beta := visc.alphaBeta(s)
+	return p.C + p.VPred.Norm() + 1.2*(alpha*p.C+beta*muMax)
+}
+
+// div v and curl v of particle i from its neighbours in sim.Neighbours. The
+// sums are normalised with the one for the positions, so linear velocity
+// fields come out exact for isotropic neighbours, without depending on Rho:
+//
+//	div v = 2 sum m v_ab . r_ab W'/r / sum m r_ab . r_ab W'/r
+func VelocityDerivatives2D(i int, sim *Simulation, kernel Kernel) {
+	p := &sim.Root.Particles[i]
+	s := &sim.State.Viscous[i]
+	nns := sim.Neighbours.Of(i)
+
+	div := 0.0
+	curl := 0.0
+	norm := 0.0
+	for j := range nns.Index {
+		if nns.Index[j] < 0 || nns.Dists[j] == 0 {
+			continue
+		}
+		nn := &sim.Root.Particles[nns.Index[j]]
+
+		rAB := nns.Pos[j].Sub(&p.Pos)
+		vAB := nn.VPred.Sub(&p.VPred)
+		dW := nn.Mass * kernelDW(kernel, nns.Dists[j], p.H) / nns.Dists[j]
+
+		div += vAB.Dot(&rAB) * dW
+		curl += (vAB.X*rAB.Y - vAB.Y*rAB.X) * dW
+		norm += rAB.Dot(&rAB) * dW
+	}
+
+	if norm == 0 {
+		s.DivV, s.CurlV = 0, 0
+	} else {
+		s.DivV = 2 * div / norm
+		s.CurlV = -2 * curl / norm
+	}
+
+	// no change of div v before the first one, the switch would take it
+	// from 0 for a shock
+	if !s.hasDivV {
+		s.DivVOld = s.DivV
+		s.hasDivV = true
+	}
+}
+
+// Evolves alpha in the state s of p over its time step dt, see top of file
+func (visc *Viscosity) UpdateAlpha(p *Particle, s *ViscousState, dt float64) {
+	if visc.Switch == NoSwitch || dt <= 0 {
+		return
+	}
+
+	tau := p.H / (visc.Decay * p.C)
+	if !(tau > 0) {
+		tau = math.Inf(1)
+	}
+
+	switch visc.Switch {
+	case MorrisMonaghan:
+		// implicit in alpha, so it stays between AlphaMin and AlphaMax
+		source := math.Max(-s.DivV, 0)
+		s.Alpha = (s.Alpha + dt*(visc.AlphaMin/tau+source*visc.AlphaMax)) / (1 + dt*(1/tau+source))
+
+	case CullenDehnen:
+		a := math.Max(-(s.DivV-s.DivVOld)/dt, 0)
+		h2 := 0.25 * p.H * p.H // smoothing length ~ H/2
+		local := visc.AlphaMin
+		if h2*a+p.C*p.C > 0 {
+			local = math.Max(visc.AlphaMax*h2*a/(h2*a+p.C*p.C), visc.AlphaMin)
+		}
+
+		if s.Alpha < local {
+			s.Alpha = local
+		} else {
+			s.Alpha = local + (s.Alpha-local)*math.Exp(-dt/tau)
+		}
+	}
+	s.DivVOld = s.DivV
+}
@@ -0,0 +1,261 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+// particles on a periodic grid in the unit square with velocity v(pos)
+func gridSimulation(n int, v func(pos Vec2) Vec2) *Simulation {
+	conf := MakeConfig()
+	conf.HorPeriodicity = [2]float64{0, 1}
+	conf.VertPeriodicity = [2]float64{0, 1}
+	conf.Kernel = WendlandC2Kernel2D
+
+	ps := make([]Particle, 0, n*n)
+	for i := range n {
+		for j := range n {
+			pos := Vec2{(float64(i) + 0.5) / float64(n), (float64(j) + 0.5) / float64(n)}
+			vel := v(pos)
+			ps = append(ps, Particle{Pos: pos, Vel: vel, VPred: vel, E: 1, EPred: 1, Mass: 1})
+		}
+	}
+
+	sim := &Simulation{Config: conf}
+	sim.Root = &Cell{Particles: ps}
+	sim.BuildTree()
+	sim.FindNearestNeighbours()
+	return sim
+}
+
+func TestVelocityDerivatives(t *testing.T) {
+	// linear fields in the middle, away from the periodic jump
+	inside := func(p *Particle) bool {
+		return math.Abs(p.Pos.X-0.5) < 0.2 && math.Abs(p.Pos.Y-0.5) < 0.2
+	}
+
+	fields := []struct {
+		name      string
+		v         func(pos Vec2) Vec2
+		div, curl float64
+	}{
+		{"expansion", func(pos Vec2) Vec2 { return Vec2{pos.X, pos.Y} }, 2, 0},
+		{"rotation", func(pos Vec2) Vec2 { return Vec2{-pos.Y, pos.X} }, 0, 2},
+		{"shear", func(pos Vec2) Vec2 { return Vec2{pos.Y, 0} }, 0, -1},
+	}
+
+	for _, field := range fields {
+		sim := gridSimulation(40, field.v)
+		for i := range sim.Root.Particles {
+			p := &sim.Root.Particles[i]
+			if !inside(p) {
+				continue
+			}
+			VelocityDerivatives2D(i, sim, sim.Config.Kernel)
+			s := &sim.State.Viscous[i]
+			if math.Abs(s.DivV-field.div) > 0.1 || math.Abs(s.CurlV-field.curl) > 0.1 {
+				t.Fatalf("%v at %v: div %v curl %v, expected %v %v", field.name, p.Pos, s.DivV, s.CurlV, field.div, field.curl)
+			}
+		}
+	}
+}
+
+func TestMorrisMonaghanSwitch(t *testing.T) {
+	visc := MakeViscosity()
+	visc.Switch = MorrisMonaghan
+
+	p := Particle{H: 0.1, C: 1}
+	s := ViscousState{Alpha: visc.Alpha}
+
+	// strong compression drives alpha up to AlphaMax
+	s.DivV = -1000
+	for range 100 {
+		visc.UpdateAlpha(&p, &s, 0.01)
+		if s.Alpha < visc.AlphaMin || s.Alpha > visc.AlphaMax {
+			t.Fatalf("alpha %v left [%v, %v]", s.Alpha, visc.AlphaMin, visc.AlphaMax)
+		}
+	}
+	if math.Abs(s.Alpha-visc.AlphaMax) > 0.01 {
+		t.Fatalf("alpha %v should be close to AlphaMax %v in a compression", s.Alpha, visc.AlphaMax)
+	}
+
+	// and decays back without
+	s.DivV = 1
+	for range 500 {
+		visc.UpdateAlpha(&p, &s, 0.01)
+	}
+	if math.Abs(s.Alpha-visc.AlphaMin) > 0.01 {
+		t.Fatalf("alpha %v should decay to AlphaMin %v", s.Alpha, visc.AlphaMin)
+	}
+}
+
+func TestCullenDehnenSwitch(t *testing.T) {
+	visc := MakeViscosity()
+	visc.Switch = CullenDehnen
+
+	p := Particle{H: 0.1, C: 1}
+	s := ViscousState{Alpha: visc.AlphaMin}
+
+	// a shock coming in, the compression gets stronger fast
+	s.DivV = -1000
+	visc.UpdateAlpha(&p, &s, 0.01)
+	if s.Alpha < 0.9*visc.AlphaMax {
+		t.Fatalf("alpha %v should jump close to AlphaMax %v", s.Alpha, visc.AlphaMax)
+	}
+
+	// steady compression, no more shock
+	for range 500 {
+		visc.UpdateAlpha(&p, &s, 0.01)
+	}
+	if math.Abs(s.Alpha-visc.AlphaMin) > 0.01 {
+		t.Fatalf("alpha %v should decay to AlphaMin %v", s.Alpha, visc.AlphaMin)
+	}
+}
+
+// the switch also works without a starting alpha
+func TestSwitchFromAlphaZero(t *testing.T) {
+	visc := MakeViscosity()
+	visc.Alpha = 0
+	visc.Switch = MorrisMonaghan
+
+	p := Particle{H: 0.1, C: 1, Rho: 1}
+	s := ViscousState{DivV: -1000}
+	visc.UpdateAlpha(&p, &s, 0.01)
+
+	alpha, beta := visc.alphaBeta(&s)
+	if alpha != s.Alpha || !(alpha > 0.9*visc.AlphaMax) || beta != visc.Beta {
+		t.Fatalf("alpha %v and beta %v in a compression, the particle has alpha %v", alpha, beta, s.Alpha)
+	}
+	if pi, _ := visc.Pi(&p, &p, &s, &s, Vec2{0.1, 0}, Vec2{-1, 0}); !(pi > 0) {
+		t.Fatalf("no viscosity between approaching particles, Pi = %v", pi)
+	}
+}
+
+// steady compression from the start, nothing for the Cullen-Dehnen switch
+func TestCullenDehnenFirstStep(t *testing.T) {
+	sim := gridSimulation(30, func(pos Vec2) Vec2 { return Vec2{0.5 - pos.X, 0.5 - pos.Y} })
+	visc := &sim.Config.Viscosity
+	visc.Switch = CullenDehnen
+	sim.Step()
+
+	for i, p := range sim.Root.Particles {
+		if math.Abs(p.Pos.X-0.5) > 0.2 || math.Abs(p.Pos.Y-0.5) > 0.2 {
+			continue
+		}
+		if s := sim.State.Viscous[i]; s.Alpha > visc.Alpha {
+			t.Fatalf("alpha %v at %v went up from %v with div v %v before and %v after", s.Alpha, p.Pos, visc.Alpha, s.DivVOld, s.DivV)
+		}
+	}
+}
+
+func TestBalsaraShear(t *testing.T) {
+	// a shear flow loses less energy with the limiter
+	lost := func(balsara bool) float64 {
+		sim := gridSimulation(30, func(pos Vec2) Vec2 { return Vec2{math.Sin(2 * math.Pi * pos.Y), 0} })
+		sim.Config.Viscosity.Balsara = balsara
+		sim.Config.DeltaTHalf = 0.001
+
+		start := sim.TotalKineticEnergy()
+		for range 10 {
+			sim.Step()
+		}
+		return start - sim.TotalKineticEnergy()
+	}
+
+	without, with := lost(false), lost(true)
+	if !(without > 0) || with > 0.2*without {
+		t.Fatalf("kinetic energy lost in the shear flow: %v without Balsara, %v with it", without, with)
+	}
+}
+
+// Channel between two walls at y = 0 and y = 1, periodic in x. The upper
+// wall slides with upperWall, see wall.go for the no-slip walls.
+func channelSimulation(mu float64, acceleration, upperWall Vec2) *Simulation {
+	const n = 20 // fluid rows
+	const dx = 1.0 / n
+
+	conf := MakeConfig()
+	conf.HorPeriodicity = [2]float64{0, 0.5}
+	conf.Kernel = WendlandC2Kernel2D
+	conf.GradH = true
+	conf.EOS = Isothermal{C: 10}
+	conf.Viscosity.Alpha = 0
+	conf.Viscosity.Beta = 0
+	conf.Viscosity.Mu = mu
+	conf.Acceleration = acceleration
+	conf.DeltaTHalf = 0.0004
+	conf.Walls.Planes = Reflections{L: -math.MaxFloat64, R: math.MaxFloat64, U: 0, D: 1}
+	conf.Walls.Velocity.D = upperWall
+	conf.Walls.Spacing = dx
+	conf.Walls.Layers = 4
+	conf.Walls.Mass = dx * dx
+
+	ps := make([]Particle, 0)
+	for i := range n / 2 {
+		for j := range n {
+			pos := Vec2{(float64(i) + 0.5) * dx, (float64(j) + 0.5) * dx}
+			ps = append(ps, Particle{Pos: pos, E: 1, Mass: dx * dx})
+		}
+	}
+
+	sim := &Simulation{Config: conf}
+	sim.AddParticles(ps, 0, false)
+	sim.AddParticles(conf.WallParticles(), 0, true)
+	sim.BuildTree()
+	return sim
+}
+
+// mean vx of the fluid particles in the y slices of the channel against the
+// analytic profile
+func checkChannelProfile(t *testing.T, sim *Simulation, profile func(y float64) float64, vMax float64) {
+	const slices = 10
+	var sum, count [slices]float64
+	for i, p := range sim.Root.Particles {
+		if sim.State.Wall[i] {
+			continue
+		}
+		k := max(0, min(int(p.Pos.Y*slices), slices-1))
+		sum[k] += p.Vel.X
+		count[k]++
+	}
+
+	for k := range slices {
+		if count[k] == 0 {
+			t.Fatalf("no fluid particles in slice %v", k)
+		}
+		y := (float64(k) + 0.5) / slices
+		v := sum[k] / count[k]
+		if math.Abs(v-profile(y)) > 0.05*vMax {
+			t.Fatalf("vx %v at y %v, expected %v", v, y, profile(y))
+		}
+	}
+}
+
+func TestPoiseuilleFlow(t *testing.T) {
+	// v(y) = g / (2 nu) y (1 - y), nu = mu / rho with rho = 1
+	const mu = 1.0
+	const g = 8.0
+	sim := channelSimulation(mu, Vec2{g, 0}, Vec2{})
+	for range 600 {
+		sim.Step()
+	}
+
+	profile := func(y float64) float64 {
+		return g / (2 * mu) * y * (1 - y)
+	}
+	checkChannelProfile(t, sim, profile, profile(0.5))
+}
+
+func TestCouetteFlow(t *testing.T) {
+	// v(y) = U y
+	const u = 1.0
+	sim := channelSimulation(1, Vec2{}, Vec2{u, 0})
+	for range 600 {
+		sim.Step()
+	}
+
+	profile := func(y float64) float64 {
+		return u * y
+	}
+	checkChannelProfile(t, sim, profile, u)
+}
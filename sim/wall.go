@@ -0,0 +1,243 @@
+/* Solid walls made of boundary particles
+
+The planes Left, Right, Up and Down of [Wall] are filled with Layers rows of
+fixed particles on the outside, enough to cover the kernel support of the
+fluid particles at the wall. So they see full neighbourhoods and their density
+doesn't drop there, unlike with the reflections.
+
+Wall particles are never moved and get no forces. Their pressure is
+extrapolated from the fluid around them (Adami et al. 2012):
+
+	P_w = (sum_f P_f W_wf + g . sum_f rho_f r_wf W_wf) / sum_f W_wf
+
+r_wf = r_w - r_f and g is Config.Acceleration, so the walls hold a
+hydrostatic column at rest. The speed of sound is extrapolated the same way,
+the density is the one of the sum like for all particles.
+
+For the physical viscosity they have the velocity
+
+	WallVel = 2 v_wall - sum_f v_f W_wf / sum_f W_wf
+
+so the fluid velocity goes to v_wall right at the plane, a no-slip wall.
+v_wall is 0 or the Velocity of the wall, e.g. the moving plate of a Couette
+flow. The particles themselves stay in place.
+
+The walls run between the other walls, or to the periodic limits or the edge
+of the Viewport. The Left and Right walls also fill the corners.
+*/
+
+package sim
+
+import (
+	"math"
+)
+
+type Walls struct {
+	Planes   Reflections    // -math.MaxFloat64 or math.MaxFloat64 is no wall
+	Velocity WallVelocities // Velocity of the wall surfaces for the viscosity
+	Spacing  float64        // Distance of the wall particles, 0 is the one of the densest [UniformRect]
+	Layers   int            // 0 covers the kernel support
+	Mass     float64        // 0 is the one of the densest [UniformRect]
+}
+
+type WallVelocities struct {
+	L Vec2
+	R Vec2
+	U Vec2
+	D Vec2
+}
+
+func MakeWalls() Walls {
+	return Walls{
+		Planes: Reflections{
+			L: -math.MaxFloat64, R: math.MaxFloat64,
+			U: -math.MaxFloat64, D: math.MaxFloat64,
+		},
+	}
+}
+
+func (walls *Walls) Any() bool {
+	return walls.Planes != MakeWalls().Planes
+}
+
+// Velocity of the wall a wall particle at pos belongs to, Up and Down in the
+// corners
+func (walls *Walls) velocityAt(pos Vec2) Vec2 {
+	switch {
+	case pos.Y < walls.Planes.U:
+		return walls.Velocity.U
+	case pos.Y > walls.Planes.D:
+		return walls.Velocity.D
+	case pos.X < walls.Planes.L:
+		return walls.Velocity.L
+	case pos.X > walls.Planes.R:
+		return walls.Velocity.R
+	}
+	return Vec2{}
+}
+
+// Spacing and mass of the particles of the densest start spawner, the walls
+// get the same density
+func (conf *SphConfig) startSpacing() (spacing, mass float64) {
+	spacing = math.Inf(1)
+	for _, spawner := range conf.Start {
+		rect, ok := spawner.(UniformRectSpawner)
+		if !ok || rect.NParticles == 0 {
+			continue
+		}
+		size := rect.LowerRight.Sub(&rect.UpperLeft)
+		s := math.Sqrt(math.Abs(size.X*size.Y) / float64(rect.NParticles))
+		if s < spacing {
+			spacing = s
+			mass = rect.Mass
+		}
+	}
+	if math.IsInf(spacing, 1) {
+		spacing = 0.01
+	}
+	if mass == 0 {
+		mass = conf.ParticleMass
+	}
+	return spacing, mass
+}
+
+// Wall particles of the config, see top of file. They are added to the
+// simulation with AddParticles() as walls.
+func (conf *SphConfig) WallParticles() []Particle {
+	walls := &conf.Walls
+	planes := walls.Planes
+	if !walls.Any() {
+		return nil
+	}
+
+	dx, mass := conf.startSpacing()
+	if walls.Spacing > 0 {
+		dx = walls.Spacing
+	}
+	if walls.Mass > 0 {
+		mass = walls.Mass
+	}
+	layers := walls.Layers
+	if layers <= 0 {
+		// the support has about NNSize particles
+		layers = int(math.Ceil(math.Sqrt(float64(conf.NNSize)/math.Pi))) + 1
+	}
+	thickness := float64(layers) * dx
+
+	// extent of the walls along the planes
+	left, right := conf.Viewport[0].X, conf.Viewport[1].X
+	up, down := conf.Viewport[0].Y, conf.Viewport[1].Y
+	if conf.HorPeriodicity[0] != -math.MaxFloat64 {
+		left, right = conf.HorPeriodicity[0], conf.HorPeriodicity[1]
+	}
+	if conf.VertPeriodicity[0] != -math.MaxFloat64 {
+		up, down = conf.VertPeriodicity[0], conf.VertPeriodicity[1]
+	}
+	hasL, hasR := planes.L != -math.MaxFloat64, planes.R != math.MaxFloat64
+	hasU, hasD := planes.U != -math.MaxFloat64, planes.D != math.MaxFloat64
+	if hasL {
+		left = planes.L
+	}
+	if hasR {
+		right = planes.R
+	}
+	if hasU {
+		up = planes.U
+	}
+	if hasD {
+		down = planes.D
+	}
+
+	particles := make([]Particle, 0)
+	add := func(x, y float64) {
+		particles = append(particles, Particle{Pos: Vec2{x, y}, Mass: mass})
+	}
+
+	// Up and Down between Left and Right
+	nx := int(math.Ceil((right-left)/dx - 1e-9))
+	for k := range layers {
+		offset := (float64(k) + 0.5) * dx
+		for i := range nx {
+			x := left + (float64(i)+0.5)*dx
+			if hasU {
+				add(x, up-offset)
+			}
+			if hasD {
+				add(x, down+offset)
+			}
+		}
+	}
+
+	// Left and Right with the corners
+	top, bottom := up, down
+	if hasU {
+		top -= thickness
+	}
+	if hasD {
+		bottom += thickness
+	}
+	ny := int(math.Ceil((bottom-top)/dx - 1e-9))
+	for k := range layers {
+		offset := (float64(k) + 0.5) * dx
+		for i := range ny {
+			y := top + (float64(i)+0.5)*dx
+			if hasL {
+				add(left-offset, y)
+			}
+			if hasR {
+				add(right+offset, y)
+			}
+		}
+	}
+
+	return particles
+}
+
+// Pressure, speed of sound and WallVel of wall particle i extrapolated from
+// the fluid in sim.Neighbours, see top of file
+func ExtrapolateWall2D(i int, sim *Simulation, kernel Kernel) {
+	p := &sim.Root.Particles[i]
+	nns := sim.Neighbours.Of(i)
+	g := sim.Config.Acceleration
+	wall := sim.Config.Walls.velocityAt(p.Pos)
+
+	pressure := 0.0
+	c := 0.0
+	vel := Vec2{}
+	norm := 0.0
+	for j := range nns.Index {
+		if nns.Index[j] < 0 {
+			continue
+		}
+		if sim.State.Wall[nns.Index[j]] {
+			continue
+		}
+		nn := &sim.Root.Particles[nns.Index[j]]
+
+		w := kernelW(kernel, nns.Dists[j], p.H)
+		rWF := p.Pos.Sub(&nns.Pos[j])
+		pressure += (nn.P + nn.Rho*g.Dot(&rWF)) * w
+		c += nn.C * w
+		vel = vel.Add(&Vec2{nn.VPred.X * w, nn.VPred.Y * w})
+		norm += w
+	}
+
+	if norm == 0 {
+		p.P, p.C = 0, 0
+		sim.State.WallVel[i] = wall
+		return
+	}
+	p.P = pressure / norm
+	p.C = c / norm
+	vel = vel.Mul(1 / norm)
+	wall = wall.Mul(2)
+	sim.State.WallVel[i] = wall.Sub(&vel)
+}
+
+// Velocity of particle b in the physical viscosity
+func (sim *Simulation) viscousVelocity(b int) Vec2 {
+	if sim.State.Wall[b] {
+		return sim.State.WallVel[b]
+	}
+	return sim.Root.Particles[b].VPred
+}
@@ -0,0 +1,200 @@
+package sim
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWallParticles(t *testing.T) {
+	conf := MakeConfig()
+	conf.Walls.Planes.L = 0
+	conf.Walls.Planes.R = 1
+	conf.Walls.Planes.D = 1
+	conf.Walls.Spacing = 0.1
+	conf.Walls.Layers = 3
+	conf.Walls.Mass = 0.5
+
+	walls := conf.WallParticles()
+
+	// the Down wall between Left and Right and the Left and Right walls from
+	// the top of the Viewport down to the bottom of the Down wall
+	if len(walls) != 3*10+2*3*13 {
+		t.Fatalf("expected %v wall particles but got %v", 3*10+2*3*13, len(walls))
+	}
+	for _, p := range walls {
+		if p.Mass != 0.5 {
+			t.Fatalf("wall particle %v without Mass", p)
+		}
+		if p.Pos.X > 0 && p.Pos.X < 1 && p.Pos.Y < 1 {
+			t.Fatalf("wall particle %v inside the walls", p.Pos)
+		}
+	}
+
+	// the corners are filled
+	corner := 0
+	for _, p := range walls {
+		if p.Pos.X < 0 && p.Pos.Y > 1 {
+			corner += 1
+		}
+	}
+	if corner != 9 {
+		t.Fatalf("expected 9 particles in the corner but got %v", corner)
+	}
+
+	conf = MakeConfig()
+	if walls := conf.WallParticles(); len(walls) != 0 {
+		t.Fatalf("expected no walls by default but got %v particles", len(walls))
+	}
+}
+
+// water column of depth n*dx in a walled box, the free surface at y = 0.5
+func columnSimulation(n int) *Simulation {
+	dx := 0.5 / float64(n)
+
+	conf := MakeConfig()
+	conf.Kernel = WendlandC2Kernel2D
+	conf.GradH = true
+	conf.EOS = Tait{Rho0: 1, C0: 10, Gamma: 7}
+	conf.Acceleration = Vec2{0, 1}
+	conf.AdaptiveTimeStep = true
+	conf.DeltaTHalf = 0.001
+	conf.Walls.Planes = Reflections{L: 0, R: 0.5, U: -math.MaxFloat64, D: 1}
+	conf.Walls.Spacing = dx
+	conf.Walls.Mass = dx * dx
+
+	ps := make([]Particle, 0, n*n)
+	for i := range n {
+		for j := range n {
+			pos := Vec2{(float64(i) + 0.5) * dx, 0.5 + (float64(j)+0.5)*dx}
+			ps = append(ps, Particle{Pos: pos, Mass: dx * dx, E: 1})
+		}
+	}
+
+	sim := &Simulation{Config: conf}
+	sim.AddParticles(ps, 0, false)
+	sim.AddParticles(conf.WallParticles(), 0, true)
+	sim.BuildTree()
+	return sim
+}
+
+func TestHydrostaticColumn(t *testing.T) {
+	const n = 16
+	const dx = 0.5 / n
+	sim := columnSimulation(n)
+	sim.Step()
+
+	walls := make(map[int]Vec2)
+	for i, p := range sim.Root.Particles {
+		if sim.State.Wall[i] {
+			walls[p.ID] = p.Pos
+		}
+	}
+
+	for step := range 600 {
+		sim.Step()
+		// damp the sloshing of the start without a hydrostatic density
+		if step < 300 {
+			for i := range sim.Root.Particles {
+				p := &sim.Root.Particles[i]
+				p.Vel = p.Vel.Mul(0.99)
+				p.VPred = p.VPred.Mul(0.99)
+			}
+		}
+	}
+
+	vMax := 0.0
+	for i, p := range sim.Root.Particles {
+		if sim.State.Wall[i] {
+			if p.Pos != walls[p.ID] || p.Vel.Norm() != 0 {
+				t.Fatalf("wall particle moved from %v to %v", walls[p.ID], p.Pos)
+			}
+			continue
+		}
+		// past the first row of wall particles
+		if p.Pos.X < -0.5*dx || p.Pos.X > 0.5+0.5*dx || p.Pos.Y > 1+0.5*dx {
+			t.Fatalf("fluid particle at %v went through the walls", p.Pos)
+		}
+		vMax = math.Max(vMax, p.Vel.Norm())
+	}
+
+	// free fall from the surface to the bottom would be 1
+	if vMax > 0.05 {
+		t.Fatalf("the column is not at rest, max velocity %v", vMax)
+	}
+
+	// P = rho0 g depth below the surface layer and away from the side walls,
+	// fitted as a line
+	var sd, sp, sdd, sdp, count float64
+	for i, p := range sim.Root.Particles {
+		depth := p.Pos.Y - 0.5
+		if sim.State.Wall[i] || depth < 0.1 || p.Pos.X < 0.1 || p.Pos.X > 0.4 {
+			continue
+		}
+		sd += depth
+		sp += p.P
+		sdd += depth * depth
+		sdp += depth * p.P
+		count += 1
+	}
+	slope := (count*sdp - sd*sp) / (count*sdd - sd*sd)
+	if math.Abs(slope-1) > 0.1 {
+		t.Fatalf("pressure grows with %v per depth, expected rho0 g = 1", slope)
+	}
+}
+
+func TestWallConfig(t *testing.T) {
+	content := `[[Simulation]]
+[Config]
+NSteps 1
+
+[[Start]]
+[UniformRect]
+NParticles 200
+UpperLeft  0.0 0.5
+LowerRight 1.0 1.0
+
+[[Boundaries]]
+[Wall]
+Left    0.0
+Right   1.0
+Down    1.0
+Layers  2
+DownVelocity 0.5 0
+`
+	fname := filepath.Join(t.TempDir(), "wall.sph-config")
+	if err := os.WriteFile(fname, []byte(content), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	conf, err := MakeConfigFromFile(fname)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	planes := conf.Walls.Planes
+	if planes.L != 0 || planes.R != 1 || planes.D != 1 || planes.U != -math.MaxFloat64 || conf.Walls.Layers != 2 || conf.Walls.Velocity.D != (Vec2{0.5, 0}) {
+		t.Fatalf("wrong walls %v", conf.Walls)
+	}
+
+	// spacing of the start spawner
+	sim := MakeSimulationFromConf(conf)
+	nWalls := 0
+	for i := range sim.Root.Particles {
+		if sim.State.Wall[i] {
+			nWalls += 1
+		}
+	}
+	if nWalls != 2*20+2*2*22 {
+		t.Fatalf("expected %v wall particles but got %v", 2*20+2*2*22, nWalls)
+	}
+
+	for _, bad := range []string{"Spacing 0", "Layers 0", "Mass -1"} {
+		if err := os.WriteFile(fname, []byte(content+bad+"\n"), 0644); err != nil {
+			t.Fatalf("%v", err)
+		}
+		if _, err := MakeConfigFromFile(fname); err == nil {
+			t.Fatalf("expected an error for `%v`", bad)
+		}
+	}
+}
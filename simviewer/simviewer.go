@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
@@ -82,6 +83,16 @@ type SimviewerState struct {
 var svState SimviewerState
 var dataViewer DataViewInfo
 
+var workersFlag = flag.Int("workers", 0, "goroutines for the simulation, overrides Workers of the config if > 0")
+var normalsFlag = flag.Bool("normals", false, "draw the surface normals of the surface tension")
+
+// command line overrides of the loaded config
+func applyFlags(simulation *sim.Simulation) {
+	if *workersFlag > 0 {
+		simulation.Config.Workers = *workersFlag
+	}
+}
+
 func run() {
 
 	W := RENDERER_W + PANEL_W
@@ -145,13 +156,15 @@ func run() {
 	sim.GenerateDefaultConfigFiles(exampleConfigFilePaths)
 
 	simulation, err := sim.MakeSimulationFromConfig(exampleConfigFilePaths[0])
+	applyFlags(&simulation)
 	if err != nil {
 		svState.TermMsg = fmt.Sprintf("%v", err)
 	} else {
-		svState.TermMsg = fmt.Sprintf("!loaded `%v` sucessfully  (Hint: config files are in the same directory as this program!) ", exampleConfigFilePaths[0])
+		svState.TermMsg = fmt.Sprintf("!loaded `%v` sucessfully  (Hint: config files are in the same directory as this program!) - tree: %v", exampleConfigFilePaths[0], simulation.Root.Stats())
 	}
 
 	animator := sim.MakeAnimator(&simulation)
+	animator.ShowNormals = *normalsFlag
 
 	go Simulator(simulationToggle, &simulation, &animator)
 
@@ -222,17 +235,20 @@ func run() {
 						simulationToggle <- false
 
 						simulation, err = sim.MakeSimulationFromConfig(configPath)
+						applyFlags(&simulation)
 						if err != nil {
 							svState.TermMsg = fmt.Sprintf("%v", err)
 						} else {
-							svState.TermMsg = fmt.Sprintf("!loaded `%v` sucessfully!", configPath)
+							svState.TermMsg = fmt.Sprintf("!loaded `%v` sucessfully! - tree: %v", configPath, simulation.Root.Stats())
 						}
 						animator = sim.MakeAnimator(&simulation)
+						animator.ShowNormals = *normalsFlag
 						svState.CurrentFrame = animator.Frames[0]
 						svState.CursorPos = 0
 						svState.AnimationRunning = false
 						dataViewer.Mutex.Lock()
 						dataViewer.Values = dataViewer.Values[:0]
+						dataViewer.Times = dataViewer.Times[:0]
 						dataViewer.Mutex.Unlock()
 					}
 				}
@@ -305,6 +321,7 @@ func Simulator(simToggle <-chan bool, simulation *sim.Simulation, animator *sim.
 
 				dataViewer.Mutex.Lock()
 				dataViewer.Values = append(dataViewer.Values, energy)
+				dataViewer.Times = append(dataViewer.Times, simulation.Time)
 				dataViewer.Mutex.Unlock()
 			}
 		}
@@ -422,12 +439,29 @@ func (dv *DataViewInfo) DrawDataViewer(cursorPos int, colorTheme *Theme, where i
 	dV := maxV - minV
 	dx := float32(rect.Dx()) / float32(len(dv.Values)+1)
 
+	// x position of frame i (value i-1), by simulated time if we have it,
+	// so with an adaptive time step the axis is real time and not steps
+	frameX := func(i int) int {
+		tMax := 0.0
+		if len(dv.Times) == len(dv.Values) {
+			tMax = dv.Times[len(dv.Times)-1]
+		}
+		if tMax <= 0 {
+			return int(dx * float32(i))
+		}
+		t := 0.0
+		if i > 0 {
+			t = dv.Times[min(i, len(dv.Times))-1]
+		}
+		return int(float64(dx) * float64(len(dv.Values)) * t / tMax)
+	}
+
 	// draw cursor
 	// TODO: make cursor here and in seeker behave the same
 	{
 		rectC := rect
-		rectC.Min.X = int(dx * float32(cursorPos))
-		rectC.Max.X = int(dx*float32(cursorPos)) + Max(int(dx), SEEKER_MIN_W)
+		rectC.Min.X = frameX(cursorPos)
+		rectC.Max.X = frameX(cursorPos) + Max(int(dx), SEEKER_MIN_W)
 		draw.Draw(drw, rectC.Intersect(rect), curCol, image.ZP, draw.Src)
 	}
 
@@ -435,8 +469,8 @@ func (dv *DataViewInfo) DrawDataViewer(cursorPos int, colorTheme *Theme, where i
 	rectC := rect
 	for i := range len(dv.Values) {
 		h := int((dv.Values[i]-minV)/dV*float64(rect.Dy())) + rect.Min.Y
-		rectC.Min.X = int(dx * float32(i+1))
-		rectC.Max.X = int(dx * float32(i+2))
+		rectC.Min.X = frameX(i + 1)
+		rectC.Max.X = frameX(i+1) + Max(int(dx), 1)
 		rectC.Min.Y = h
 		rectC.Max.Y = h + 2
 		draw.Draw(drw, rectC, col, image.ZP, draw.Src)
@@ -449,6 +483,7 @@ func (dv *DataViewInfo) DrawDataViewer(cursorPos int, colorTheme *Theme, where i
 type DataViewInfo struct {
 	Label  string
 	Values []float64
+	Times  []float64 // simulated time of each value
 	Mutex  sync.Mutex
 }
 
@@ -543,5 +578,6 @@ func Max(a, b int) int {
 }
 
 func main() {
+	flag.Parse()
 	run()
 }